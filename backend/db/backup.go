@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupPrefix = "backup-"
+	backupSuffix = ".db"
+)
+
+// Scheduled and on-demand backups must not write at the same time
+var backupMu sync.Mutex
+
+// Backup writes a consistent copy of the database into dir with VACUUM INTO
+// and then deletes all but the newest keep backups there (keep <= 0 keeps
+// everything). It returns the path of the new file.
+func Backup(dir string, keep int) (string, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// Timestamps sort lexicographically, which pruning relies on
+	name := backupPrefix + time.Now().UTC().Format("20060102T150405.000Z") + backupSuffix
+	path := filepath.Join(dir, name)
+	if _, err := DB.Exec("VACUUM INTO ?", path); err != nil {
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	if keep > 0 {
+		if err := pruneBackups(dir, keep); err != nil {
+			log.Printf("Warning: failed to prune old backups: %v", err)
+		}
+	}
+	return path, nil
+}
+
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// StartBackups runs Backup every interval until the process exits
+func StartBackups(dir string, interval time.Duration, keep int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			path, err := Backup(dir, keep)
+			if err != nil {
+				log.Printf("Scheduled backup failed: %v", err)
+				continue
+			}
+			log.Printf("Database backed up to %s", path)
+		}
+	}()
+}
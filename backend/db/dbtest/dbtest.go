@@ -0,0 +1,33 @@
+// Package dbtest sets up throwaway migrated databases for tests
+package dbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"emoons-web/db"
+)
+
+// Setup connects the db package to a fresh, fully migrated SQLite file in a
+// temporary directory, closed when tb finishes
+func Setup(tb testing.TB) {
+	tb.Helper()
+
+	if err := db.Connect(filepath.Join(tb.TempDir(), "test.db")); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(db.Close)
+
+	if err := db.RunMigrations(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// Exec runs setup statements, failing tb on the first error
+func Exec(tb testing.TB, query string, args ...any) {
+	tb.Helper()
+
+	if _, err := db.DB.Exec(query, args...); err != nil {
+		tb.Fatalf("%s: %v", query, err)
+	}
+}
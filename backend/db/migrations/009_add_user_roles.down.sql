@@ -0,0 +1,2 @@
+-- SQLite 3.35.0+ required for DROP COLUMN
+ALTER TABLE Users DROP COLUMN role;
@@ -0,0 +1,4 @@
+-- Replace the is_admin flag with a role (is_admin is kept in sync during the transition)
+ALTER TABLE Users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+
+UPDATE Users SET role = 'admin' WHERE is_admin = 1;
@@ -0,0 +1,3 @@
+-- SQLite 3.35.0+ required for DROP COLUMN
+ALTER TABLE Users DROP COLUMN updated_at;
+ALTER TABLE Users DROP COLUMN created_at;
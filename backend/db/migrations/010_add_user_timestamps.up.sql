@@ -0,0 +1,6 @@
+-- SQLite doesn't allow CURRENT_TIMESTAMP as a default in ADD COLUMN,
+-- so existing rows are backfilled and inserts set the values explicitly
+ALTER TABLE Users ADD COLUMN created_at DATETIME;
+ALTER TABLE Users ADD COLUMN updated_at DATETIME;
+
+UPDATE Users SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_classification_tags_tag;
+DROP TABLE IF EXISTS ClassificationTags;
@@ -0,0 +1,9 @@
+-- Free-form reusable labels attached to a classification
+CREATE TABLE IF NOT EXISTS ClassificationTags (
+    classification_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    FOREIGN KEY (classification_id) REFERENCES Classifications(id) ON DELETE CASCADE,
+    UNIQUE (classification_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_classification_tags_tag ON ClassificationTags(tag);
@@ -0,0 +1,9 @@
+-- Curves a user has explicitly marked as reviewed
+CREATE TABLE IF NOT EXISTS CurveCompletions (
+    curve_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    completed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    UNIQUE (curve_id, user_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE Classifications DROP COLUMN locked;
@@ -0,0 +1 @@
+ALTER TABLE Classifications ADD COLUMN locked BOOLEAN DEFAULT 0;
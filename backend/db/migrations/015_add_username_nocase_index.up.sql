@@ -0,0 +1,2 @@
+-- Fails if accounts differing only in case already exist; merge or rename them first
+CREATE UNIQUE INDEX idx_users_username_nocase ON Users(username COLLATE NOCASE);
@@ -0,0 +1,9 @@
+-- Curves an admin has assigned to a user to classify
+CREATE TABLE IF NOT EXISTS CurveAssignments (
+    curve_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    assigned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    UNIQUE (curve_id, user_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE Curves DROP COLUMN curve_notes;
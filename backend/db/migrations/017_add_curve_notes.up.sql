@@ -0,0 +1 @@
+ALTER TABLE Curves ADD COLUMN curve_notes TEXT;
@@ -0,0 +1,12 @@
+-- Transits a classifier escalated for admin attention (transit_index is 0-based, as in Classifications)
+CREATE TABLE IF NOT EXISTS ReviewFlags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    curve_id INTEGER NOT NULL,
+    transit_index INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    UNIQUE (curve_id, transit_index, user_id)
+);
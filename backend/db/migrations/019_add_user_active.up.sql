@@ -0,0 +1 @@
+ALTER TABLE Users ADD COLUMN active BOOLEAN NOT NULL DEFAULT 1;
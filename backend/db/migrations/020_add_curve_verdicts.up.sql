@@ -0,0 +1,11 @@
+-- Whole-curve triage verdicts, recorded alongside the per-transit classifications
+CREATE TABLE IF NOT EXISTS CurveVerdicts (
+    curve_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    verdict TEXT NOT NULL,
+    notes TEXT,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (curve_id, user_id),
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+-- Curated transits with known answers for onboarding classifiers
+-- (transit_index is 0-based, as in Classifications; expected_flags lists the
+-- flags that should be set, comma-separated, all others should be clear)
+CREATE TABLE IF NOT EXISTS TrainingExamples (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    curve_id INTEGER NOT NULL,
+    transit_index INTEGER NOT NULL,
+    expected_flags TEXT NOT NULL DEFAULT '',
+    created_by INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (created_by) REFERENCES Users(id) ON DELETE SET NULL,
+    UNIQUE (curve_id, transit_index)
+);
@@ -0,0 +1,20 @@
+-- Authoritative answer for a transit chosen by a reviewer after adjudication
+-- (transit_index is 0-based, as in Classifications)
+CREATE TABLE IF NOT EXISTS FinalClassifications (
+    curve_id INTEGER NOT NULL,
+    transit_index INTEGER NOT NULL,
+    reviewer_id INTEGER,
+    normal_transit BOOLEAN NOT NULL DEFAULT 0,
+    anomalous_morphology BOOLEAN NOT NULL DEFAULT 0,
+    left_asymmetry BOOLEAN NOT NULL DEFAULT 0,
+    right_asymmetry BOOLEAN NOT NULL DEFAULT 0,
+    increased_flux BOOLEAN NOT NULL DEFAULT 0,
+    decreased_flux BOOLEAN NOT NULL DEFAULT 0,
+    marked_tdv BOOLEAN NOT NULL DEFAULT 0,
+    bad_model_fit BOOLEAN NOT NULL DEFAULT 0,
+    notes TEXT,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (curve_id, transit_index),
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (reviewer_id) REFERENCES Users(id) ON DELETE SET NULL
+);
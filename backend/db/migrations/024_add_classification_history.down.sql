@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS classification_history_update;
+DROP TRIGGER IF EXISTS classification_history_insert;
+DROP TABLE IF EXISTS ClassificationHistory;
@@ -0,0 +1,79 @@
+-- Every saved version of a classification (transit_index is 0-based, as in
+-- Classifications). Filled by triggers so all write paths are recorded.
+CREATE TABLE IF NOT EXISTS ClassificationHistory (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    curve_id INTEGER NOT NULL,
+    transit_index INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    t_expected_bjd REAL,
+    t_observed_bjd REAL,
+    ttv_minutes REAL,
+    left_asymmetry BOOLEAN,
+    right_asymmetry BOOLEAN,
+    increased_flux BOOLEAN,
+    decreased_flux BOOLEAN,
+    normal_transit BOOLEAN,
+    anomalous_morphology BOOLEAN,
+    marked_tdv BOOLEAN,
+    bad_model_fit BOOLEAN,
+    notes TEXT,
+    version_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (curve_id) REFERENCES Curves(id),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_classification_history_transit
+    ON ClassificationHistory(curve_id, transit_index);
+
+-- Existing classifications become the first recorded version
+INSERT INTO ClassificationHistory (
+    curve_id, transit_index, user_id, t_expected_bjd, t_observed_bjd, ttv_minutes,
+    left_asymmetry, right_asymmetry, increased_flux, decreased_flux, normal_transit,
+    anomalous_morphology, marked_tdv, bad_model_fit, notes, version_at
+)
+SELECT
+    curve_id, transit_index, user_id, t_expected_bjd, t_observed_bjd, ttv_minutes,
+    left_asymmetry, right_asymmetry, increased_flux, decreased_flux, normal_transit,
+    anomalous_morphology, marked_tdv, bad_model_fit, notes, COALESCE(timestamp, CURRENT_TIMESTAMP)
+FROM Classifications;
+
+CREATE TRIGGER IF NOT EXISTS classification_history_insert
+AFTER INSERT ON Classifications
+BEGIN
+    INSERT INTO ClassificationHistory (
+        curve_id, transit_index, user_id, t_expected_bjd, t_observed_bjd, ttv_minutes,
+        left_asymmetry, right_asymmetry, increased_flux, decreased_flux, normal_transit,
+        anomalous_morphology, marked_tdv, bad_model_fit, notes, version_at
+    ) VALUES (
+        NEW.curve_id, NEW.transit_index, NEW.user_id, NEW.t_expected_bjd, NEW.t_observed_bjd, NEW.ttv_minutes,
+        NEW.left_asymmetry, NEW.right_asymmetry, NEW.increased_flux, NEW.decreased_flux, NEW.normal_transit,
+        NEW.anomalous_morphology, NEW.marked_tdv, NEW.bad_model_fit, NEW.notes,
+        COALESCE(NEW.timestamp, CURRENT_TIMESTAMP)
+    );
+END;
+
+-- Saves bump the timestamp; lock changes alone don't make a new version
+CREATE TRIGGER IF NOT EXISTS classification_history_update
+AFTER UPDATE ON Classifications
+WHEN OLD.timestamp IS NOT NEW.timestamp
+    OR OLD.left_asymmetry IS NOT NEW.left_asymmetry
+    OR OLD.right_asymmetry IS NOT NEW.right_asymmetry
+    OR OLD.increased_flux IS NOT NEW.increased_flux
+    OR OLD.decreased_flux IS NOT NEW.decreased_flux
+    OR OLD.normal_transit IS NOT NEW.normal_transit
+    OR OLD.anomalous_morphology IS NOT NEW.anomalous_morphology
+    OR OLD.marked_tdv IS NOT NEW.marked_tdv
+    OR OLD.bad_model_fit IS NOT NEW.bad_model_fit
+    OR OLD.notes IS NOT NEW.notes
+BEGIN
+    INSERT INTO ClassificationHistory (
+        curve_id, transit_index, user_id, t_expected_bjd, t_observed_bjd, ttv_minutes,
+        left_asymmetry, right_asymmetry, increased_flux, decreased_flux, normal_transit,
+        anomalous_morphology, marked_tdv, bad_model_fit, notes, version_at
+    ) VALUES (
+        NEW.curve_id, NEW.transit_index, NEW.user_id, NEW.t_expected_bjd, NEW.t_observed_bjd, NEW.ttv_minutes,
+        NEW.left_asymmetry, NEW.right_asymmetry, NEW.increased_flux, NEW.decreased_flux, NEW.normal_transit,
+        NEW.anomalous_morphology, NEW.marked_tdv, NEW.bad_model_fit, NEW.notes,
+        COALESCE(NEW.timestamp, CURRENT_TIMESTAMP)
+    );
+END;
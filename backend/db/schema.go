@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Tables and the columns the application relies on, as left by the migrations
+var expectedSchema = map[string][]string{
+	"Users": {
+		"id", "username", "password_hash", "fullname", "is_admin", "role", "created_at", "updated_at",
+		"classification_quota", "active",
+	},
+	"Curves": {
+		"id", "filename", "time_min", "time_max", "num_expected_transits", "found_transits",
+		"data_type", "period_days", "epoch_bjd", "duration_days", "planet_radius",
+		"semi_major_axis", "inclination_deg", "u1", "u2", "curve_notes",
+	},
+	"Transits": {
+		"id", "curve_id", "transit_index", "t0_expected", "t0_fitted", "ttv_minutes",
+		"rp_fitted", "a_fitted", "rms_residuals", "period", "duration", "inc", "u1", "u2", "plot_file",
+	},
+	"Classifications": {
+		"id", "curve_id", "transit_index", "user_id", "t_expected_bjd", "t_observed_bjd",
+		"ttv_minutes", "left_asymmetry", "right_asymmetry", "increased_flux", "decreased_flux",
+		"normal_transit", "anomalous_morphology", "marked_tdv", "bad_model_fit", "notes", "timestamp",
+		"locked",
+	},
+	"Sessions": {
+		"id", "user_id", "created_at", "last_seen",
+	},
+	"ClassificationTags": {
+		"classification_id", "tag",
+	},
+	"CurveCompletions": {
+		"curve_id", "user_id", "completed_at",
+	},
+	"CurveAssignments": {
+		"curve_id", "user_id", "assigned_at",
+	},
+	"ReviewFlags": {
+		"id", "curve_id", "transit_index", "user_id", "reason", "created_at",
+	},
+	"CurveVerdicts": {
+		"curve_id", "user_id", "verdict", "notes", "updated_at",
+	},
+	"AuditLog": {
+		"id", "actor_id", "action", "path", "status", "created_at",
+	},
+	"FinalClassifications": {
+		"curve_id", "transit_index", "reviewer_id", "normal_transit", "anomalous_morphology",
+		"left_asymmetry", "right_asymmetry", "increased_flux", "decreased_flux", "marked_tdv",
+		"bad_model_fit", "notes", "updated_at",
+	},
+	"ClassificationHistory": {
+		"id", "curve_id", "transit_index", "user_id", "t_expected_bjd", "t_observed_bjd",
+		"ttv_minutes", "left_asymmetry", "right_asymmetry", "increased_flux", "decreased_flux",
+		"normal_transit", "anomalous_morphology", "marked_tdv", "bad_model_fit", "notes", "version_at",
+	},
+	"TrainingExamples": {
+		"id", "curve_id", "transit_index", "expected_flags", "created_by", "created_at",
+	},
+}
+
+func VerifySchema() error {
+	var problems []string
+
+	tables := make([]string, 0, len(expectedSchema))
+	for table := range expectedSchema {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		columns := expectedSchema[table]
+		var count int
+		err := DB.QueryRow(
+			"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+			table,
+		).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if count == 0 {
+			problems = append(problems, fmt.Sprintf("missing table %s", table))
+			continue
+		}
+
+		existing, err := tableColumns(table)
+		if err != nil {
+			return fmt.Errorf("failed to inspect columns of %s: %w", table, err)
+		}
+		for _, column := range columns {
+			if !existing[column] {
+				problems = append(problems, fmt.Sprintf("missing column %s.%s", table, column))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("database schema does not match migrations: %s", strings.Join(problems, "; "))
+	}
+
+	log.Println("Database schema verified")
+	return nil
+}
+
+func tableColumns(table string) (map[string]bool, error) {
+	// PRAGMA arguments can't be bound; table names come from expectedSchema only
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue any
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
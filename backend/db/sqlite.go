@@ -1,10 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
@@ -17,6 +20,22 @@ var migrationsFS embed.FS
 
 var DB *sql.DB
 
+// ReadDB serves heavy read-only queries (listings, stats, exports). It is the
+// replica when one is configured and DB otherwise, so writes never go here.
+var ReadDB *sql.DB
+
+// QueryTimeout bounds every query run under WithQueryTimeout; zero means no limit
+var QueryTimeout time.Duration
+
+// WithQueryTimeout derives the context for an expensive query from ctx,
+// normally the request's so a client disconnect also cancels the query
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if QueryTimeout > 0 {
+		return context.WithTimeout(ctx, QueryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
 func Connect(dbPath string) error {
 	var err error
 	DB, err = sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
@@ -28,24 +47,60 @@ func Connect(dbPath string) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	ReadDB = DB
 	log.Printf("Connected to database: %s", dbPath)
 	return nil
 }
 
-func RunMigrations() error {
+// ConnectReplica opens a read-only replica and routes ReadDB to it; call it after Connect
+func ConnectReplica(replicaPath string) error {
+	replica, err := sql.Open("sqlite3", "file:"+replicaPath+"?mode=ro&_foreign_keys=on")
+	if err != nil {
+		return fmt.Errorf("failed to open replica database: %w", err)
+	}
+
+	if err = replica.Ping(); err != nil {
+		replica.Close()
+		return fmt.Errorf("failed to ping replica database: %w", err)
+	}
+
+	ReadDB = replica
+	log.Printf("Connected to read replica: %s", replicaPath)
+	return nil
+}
+
+func newMigrate() (*migrate.Migrate, error) {
 	driver, err := sqlite3.WithInstance(DB, &sqlite3.Config{})
 	if err != nil {
-		return fmt.Errorf("failed to create migration driver: %w", err)
+		return nil, fmt.Errorf("failed to create migration driver: %w", err)
 	}
 
 	source, err := iofs.New(migrationsFS, "migrations")
 	if err != nil {
-		return fmt.Errorf("failed to create migration source: %w", err)
+		return nil, fmt.Errorf("failed to create migration source: %w", err)
 	}
 
 	m, err := migrate.NewWithInstance("iofs", source, "sqlite3", driver)
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	return m, nil
+}
+
+func RunMigrations() error {
+	m, err := newMigrate()
+	if err != nil {
+		return err
+	}
+
+	version, _, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return fmt.Errorf("failed to read migration version: %w", err)
+	}
+	if version < usernameNocaseMigration {
+		if err := checkUsernameCaseDuplicates(); err != nil {
+			return err
+		}
 	}
 
 	if err = m.Up(); err != nil && err != migrate.ErrNoChange {
@@ -56,7 +111,59 @@ func RunMigrations() error {
 	return nil
 }
 
+// Migration adding the case-insensitive unique index on usernames
+const usernameNocaseMigration = 15
+
+// checkUsernameCaseDuplicates names the accounts that would make the
+// case-insensitive username index fail, instead of a bare constraint error
+func checkUsernameCaseDuplicates() error {
+	// The table is still called Usuarios before the rename to English
+	var table string
+	err := DB.QueryRow(`
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name IN ('Users', 'Usuarios')
+		ORDER BY name = 'Users' DESC LIMIT 1
+	`).Scan(&table)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check usernames: %w", err)
+	}
+
+	rows, err := DB.Query(`
+		SELECT GROUP_CONCAT(username, ', ' ORDER BY id) FROM ` + table + `
+		GROUP BY username COLLATE NOCASE
+		HAVING COUNT(*) > 1
+		ORDER BY MIN(id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to check usernames: %w", err)
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var names string
+		if err := rows.Scan(&names); err != nil {
+			return fmt.Errorf("failed to check usernames: %w", err)
+		}
+		duplicates = append(duplicates, "["+names+"]")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check usernames: %w", err)
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("usernames must be unique ignoring case before migration %d; rename or merge these accounts: %s",
+			usernameNocaseMigration, strings.Join(duplicates, " "))
+	}
+	return nil
+}
+
 func Close() {
+	if ReadDB != nil && ReadDB != DB {
+		ReadDB.Close()
+	}
 	if DB != nil {
 		DB.Close()
 	}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestMigrationReportsUsernameCaseDuplicates(t *testing.T) {
+	if err := Connect(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+
+	m, err := newMigrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(usernameNocaseMigration - 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Alice", "alice", "bob", "Carol", "CAROL", "carol"} {
+		if _, err := DB.Exec(`INSERT INTO Users (username, password_hash, fullname) VALUES (?, 'x', ?)`, name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = RunMigrations()
+	if err == nil {
+		t.Fatal("migrating with case-duplicate usernames succeeded")
+	}
+	for _, want := range []string{"[Alice, alice]", "[Carol, CAROL, carol]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not name %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "bob") {
+		t.Errorf("error %q names a unique username", err)
+	}
+	if version, dirty, _ := m.Version(); version != usernameNocaseMigration-1 || dirty {
+		t.Errorf("after refused migration: version %d (dirty %v), want clean %d", version, dirty, usernameNocaseMigration-1)
+	}
+
+	if _, err := DB.Exec(`DELETE FROM Users WHERE username IN ('alice', 'CAROL', 'carol')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunMigrations(); err != nil {
+		t.Fatalf("migrating after cleanup: %v", err)
+	}
+	if _, err := DB.Exec(`INSERT INTO Users (username, password_hash, fullname) VALUES ('ALICE', 'x', 'x')`); err == nil {
+		t.Error("case-duplicate username inserted after the index was created")
+	}
+}
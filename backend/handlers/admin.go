@@ -1,13 +1,27 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
+	"emoons-web/db"
+	"emoons-web/middleware"
 	"emoons-web/models"
-	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
 )
 
 func ListUsers(c *gin.Context) {
@@ -23,6 +37,7 @@ type CreateUserRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Fullname string `json:"fullname" binding:"required"`
+	Role     string `json:"role"`
 	IsAdmin  bool   `json:"is_admin"`
 }
 
@@ -33,7 +48,28 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	user, err := models.CreateUser(req.Username, req.Password, req.Fullname, req.IsAdmin)
+	// Clients that only know about is_admin still work
+	role := req.Role
+	if role == "" {
+		role = models.RoleUser
+		if req.IsAdmin {
+			role = models.RoleAdmin
+		}
+	}
+	if !models.ValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	user, err := models.CreateUser(req.Username, req.Password, req.Fullname, role)
+	if errors.Is(err, models.ErrInvalidUsername) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username"})
+		return
+	}
+	if errors.Is(err, models.ErrUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
@@ -44,14 +80,17 @@ func CreateUser(c *gin.Context) {
 
 type UpdateUserRequest struct {
 	Fullname string `json:"fullname" binding:"required"`
+	Role     string `json:"role"`
 	IsAdmin  bool   `json:"is_admin"`
+	// Omitted leaves the quota untouched, null removes it
+	ClassificationQuota json.RawMessage `json:"classification_quota"`
 }
 
 func UpdateUser(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
 		return
 	}
 
@@ -61,11 +100,56 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	if err := models.UpdateUser(id, req.Fullname, req.IsAdmin); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	user, err := models.GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	// Without an explicit role, is_admin toggles admin while keeping reviewers as they are
+	role := req.Role
+	if role == "" {
+		switch {
+		case req.IsAdmin:
+			role = models.RoleAdmin
+		case user.Role == models.RoleAdmin:
+			role = models.RoleUser
+		default:
+			role = user.Role
+		}
+	}
+	if !models.ValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	var quota *int
+	if len(req.ClassificationQuota) > 0 {
+		if err := json.Unmarshal(req.ClassificationQuota, &quota); err != nil || (quota != nil && *quota < 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid classification quota"})
+			return
+		}
+	}
+
+	if err := models.UpdateUser(id, req.Fullname, role); err != nil {
+		switch {
+		case errors.Is(err, models.ErrLastAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the last admin"})
+		case errors.Is(err, models.ErrUnknownUser):
+			c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		}
 		return
 	}
 
+	if len(req.ClassificationQuota) > 0 {
+		if err := models.SetClassificationQuota(id, quota); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User updated"})
 }
 
@@ -73,27 +157,118 @@ func DeleteUser(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
 		return
 	}
 
 	if err := models.DeleteUser(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		switch {
+		case errors.Is(err, models.ErrLastAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the last admin"})
+		case errors.Is(err, models.ErrUnknownUser):
+			c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 }
 
+func DeactivateUser(c *gin.Context) {
+	setUserActive(c, false)
+}
+
+func ActivateUser(c *gin.Context) {
+	setUserActive(c, true)
+}
+
+func setUserActive(c *gin.Context, active bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	if !active && id == middleware.GetUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot deactivate your own account"})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	if err := models.SetUserActive(id, active); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	if !active {
+		// Revokes outstanding tokens immediately
+		if err := models.DeleteUserSessions(id); err != nil {
+			log.Printf("Failed to delete sessions of user %d: %v", id, err)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User deactivated"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User activated"})
+}
+
+// GetAuditLog pages through admin actions, filtered by actor_id, action and
+// from/to (as in the exports)
+func GetAuditLog(c *gin.Context) {
+	var opts models.AuditLogOptions
+	var err error
+
+	if v := c.Query("actor_id"); v != "" {
+		opts.ActorID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || opts.ActorID < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor_id"})
+			return
+		}
+	}
+	opts.Action = c.Query("action")
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.From, opts.To = filter.From, filter.To
+
+	opts.Page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || opts.Page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	opts.PerPage, err = strconv.Atoi(c.DefaultQuery("per_page", "50"))
+	if err != nil || opts.PerPage < 1 || opts.PerPage > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid per_page"})
+		return
+	}
+
+	page, err := models.GetAuditLog(opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
 func GetUserStats(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
 		return
 	}
 
-	stats, err := models.GetDetailedUserStats(id)
+	// ?fresh=true recomputes instead of serving the cached stats
+	stats, err := models.GetCachedUserStats(c.Request.Context(), id, c.Query("fresh") == "true")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user stats"})
 		return
@@ -102,80 +277,1262 @@ func GetUserStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+func GetUserThroughput(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	days := 7
+	if v := c.Query("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+			return
+		}
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	throughput, err := models.GetUserThroughput(id, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get throughput"})
+		return
+	}
+
+	c.JSON(http.StatusOK, throughput)
+}
+
+func GetGoldAgreement(c *gin.Context) {
+	goldUserID, err := strconv.ParseInt(c.Query("gold_user"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gold_user"})
+		return
+	}
+
+	if _, err := models.GetUserByID(goldUserID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	agreement, err := models.GetGoldAgreement(goldUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute agreement"})
+		return
+	}
+
+	c.JSON(http.StatusOK, agreement)
+}
+
+func GetUserFlagMetrics(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	metrics, err := models.GetUserFlagMetrics(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute metrics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, metrics)
+}
+
+func GetConfusionMatrix(c *gin.Context) {
+	userA, err := strconv.ParseInt(c.Query("user_a"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_a"})
+		return
+	}
+	userB, err := strconv.ParseInt(c.Query("user_b"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_b"})
+		return
+	}
+
+	flag := models.ResolveFlag(c.Query("flag"))
+	if flag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown flag: " + c.Query("flag")})
+		return
+	}
+
+	matrix, err := models.GetConfusionMatrix(userA, userB, flag)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute confusion matrix"})
+		return
+	}
+
+	c.JSON(http.StatusOK, matrix)
+}
+
+func GetAdminStatsByDataType(c *gin.Context) {
+	stats, err := models.GetStatsByDataType(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+func GetUserProgress(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && !models.ValidCurveStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	progress, err := models.GetUserCurveProgress(id, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
 func ExportUserClassifications(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
 		return
 	}
 
 	// Get user info for filename
 	user, err := models.GetUserByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	classifications, err := models.GetUserClassificationsForExport(id)
+	format, err := parseExportFormat(c, "csv", "ecsv")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get classifications"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Set headers for CSV download
-	filename := fmt.Sprintf("classifications_%s.csv", user.Username)
-	c.Header("Content-Type", "text/csv")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	classifications, err := models.GetUserClassificationsForExport(c.Request.Context(), id, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
 
-	writer := csv.NewWriter(c.Writer)
+	writer := startExportTable(c, format, "classifications_"+user.Username, classificationExportColumns)
 	defer writer.Flush()
 
-	// Write header
-	header := []string{
-		"curve", "transit_index",
-		"normal_transit", "anomalous_morphology",
-		"left_asymmetry", "right_asymmetry",
-		"increased_flux", "decreased_flux",
-		"marked_tdv", "bad_model_fit",
-		"t_expected_bjd", "t_observed_bjd", "ttv_minutes",
-		"notes", "timestamp",
+	for _, cl := range classifications {
+		writer.Write(classificationExportRow(cl))
 	}
-	writer.Write(header)
+}
 
-	// Write data
-	for _, cl := range classifications {
-		row := []string{
-			cl.CurveName,
-			strconv.Itoa(cl.TransitIndex),
-			boolToStr(cl.NormalTransit),
-			boolToStr(cl.AnomalousMorphology),
-			boolToStr(cl.LeftAsymmetry),
-			boolToStr(cl.RightAsymmetry),
-			boolToStr(cl.IncreasedFlux),
-			boolToStr(cl.DecreasedFlux),
-			boolToStr(cl.MarkedTDV),
-			boolToStr(cl.BadModelFit),
-			floatPtrToStr(cl.TExpectedBJD),
-			floatPtrToStr(cl.TObservedBJD),
-			floatPtrToStr(cl.TTVMinutes),
-			cl.Notes,
-			cl.Timestamp,
-		}
-		writer.Write(row)
+// parseExportFilter reads the optional from/to query parameters, given as
+// RFC3339 timestamps or YYYY-MM-DD dates. A date in "to" includes that whole day.
+func parseExportFilter(c *gin.Context) (models.ExportFilter, error) {
+	var filter models.ExportFilter
+	if v := c.Query("from"); v != "" {
+		t, _, err := parseExportTime(v)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid from: %s", v)
+		}
+		filter.From = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, dateOnly, err := parseExportTime(v)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid to: %s", v)
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		filter.To = &t
 	}
+	return filter, nil
 }
 
-func boolToStr(b bool) string {
-	if b {
-		return "1"
+func parseExportTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
 	}
-	return "0"
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
 }
 
-func floatPtrToStr(f *float64) string {
-	if f == nil {
-		return ""
+func CountUserClassificationsForExport(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.CountUserClassificationsForExport(c.Request.Context(), id, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count classifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rows": count})
+}
+
+func CountAllClassificationsForExport(c *gin.Context) {
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.CountAllClassificationsForExport(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count classifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rows": count})
+}
+
+var usernameExportColumn = exportColumn{name: "username", datatype: "string"}
+
+var classificationExportColumns = []exportColumn{
+	{name: "curve", datatype: "string"},
+	{name: "transit_index", datatype: "int64", description: "0-based transit index"},
+	{name: "normal_transit", datatype: "bool"},
+	{name: "anomalous_morphology", datatype: "bool"},
+	{name: "left_asymmetry", datatype: "bool"},
+	{name: "right_asymmetry", datatype: "bool"},
+	{name: "increased_flux", datatype: "bool"},
+	{name: "decreased_flux", datatype: "bool"},
+	{name: "marked_tdv", datatype: "bool"},
+	{name: "bad_model_fit", datatype: "bool"},
+	{name: "t_expected_bjd", datatype: "float64", unit: "d", description: "Expected mid-transit time (BJD)"},
+	{name: "t_observed_bjd", datatype: "float64", unit: "d", description: "Observed mid-transit time (BJD)"},
+	{name: "ttv_minutes", datatype: "float64", unit: "min", description: "Transit timing variation"},
+	{name: "notes", datatype: "string"},
+	{name: "timestamp", datatype: "string", description: "Classification time (RFC3339, UTC)"},
+	{name: "final_normal_transit", datatype: "bool", description: "Adjudicated answer, empty if not adjudicated"},
+	{name: "final_anomalous_morphology", datatype: "bool"},
+	{name: "final_left_asymmetry", datatype: "bool"},
+	{name: "final_right_asymmetry", datatype: "bool"},
+	{name: "final_increased_flux", datatype: "bool"},
+	{name: "final_decreased_flux", datatype: "bool"},
+	{name: "final_marked_tdv", datatype: "bool"},
+	{name: "final_bad_model_fit", datatype: "bool"},
+	{name: "final_notes", datatype: "string"},
+}
+
+var raterExportColumns = append([]exportColumn{usernameExportColumn}, classificationExportColumns...)
+
+// Columns of classificationExportRow up to and including timestamp, before the final_* ones
+var classificationValueColumns = slices.IndexFunc(classificationExportColumns, func(col exportColumn) bool {
+	return strings.HasPrefix(col.name, "final_")
+})
+
+// History rows have no adjudicated answer and their timestamp is the version's
+var historyExportColumns = append(append([]exportColumn{usernameExportColumn},
+	classificationExportColumns[:classificationValueColumns-1]...),
+	exportColumn{name: "version_at", datatype: "string", description: "Time this version was saved (RFC3339, UTC)"})
+
+func classificationExportRow(cl models.ClassificationExport) []string {
+	row := []string{
+		cl.CurveName,
+		strconv.Itoa(cl.TransitIndex),
+		boolToStr(cl.NormalTransit),
+		boolToStr(cl.AnomalousMorphology),
+		boolToStr(cl.LeftAsymmetry),
+		boolToStr(cl.RightAsymmetry),
+		boolToStr(cl.IncreasedFlux),
+		boolToStr(cl.DecreasedFlux),
+		boolToStr(cl.MarkedTDV),
+		boolToStr(cl.BadModelFit),
+		floatPtrToStr(cl.TExpectedBJD),
+		floatPtrToStr(cl.TObservedBJD),
+		floatPtrToStr(cl.TTVMinutes),
+		cl.Notes,
+		cl.Timestamp,
+	}
+	if cl.Final == nil {
+		return append(row, make([]string, len(models.ClassificationFlags)+1)...)
+	}
+	for _, flag := range models.ClassificationFlags {
+		row = append(row, boolToStr(cl.Final.Flags[flag]))
+	}
+	return append(row, cl.Final.Notes)
+}
+
+// ExportAllClassifications exports every user's classifications as CSV;
+// ?anonymize=true replaces usernames with opaque IDs keyed by anonymizeKey
+func ExportAllClassifications(anonymizeKey []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := parseExportFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		format, err := parseExportFormat(c, "csv", "ecsv")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		exports, err := models.GetAllClassificationsForExport(c.Request.Context(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+			return
+		}
+
+		basename := "classifications_all"
+		if c.Query("anonymize") == "true" {
+			// An empty key would make the IDs a plain hash of the user ID
+			if len(anonymizeKey) == 0 {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Anonymization key not configured"})
+				return
+			}
+			models.AnonymizeRaters(exports, anonymizeKey)
+			basename = "classifications_all_anonymized"
+		}
+
+		writer := startExportTable(c, format, basename, raterExportColumns)
+		defer writer.Flush()
+
+		for _, e := range exports {
+			writer.Write(append([]string{e.Username}, classificationExportRow(e.ClassificationExport)...))
+		}
+	}
+}
+
+func ExportDisagreements(c *gin.Context) {
+	minRaters := 2
+	if v := c.Query("min"); v != "" {
+		m, err := strconv.Atoi(v)
+		if err != nil || m < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min must be an integer >= 2"})
+			return
+		}
+		minRaters = m
+	}
+
+	format, err := parseExportFormat(c, "csv", "ecsv", "json")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ratings, err := models.GetDisagreementRatings(c.Request.Context(), minRaters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get disagreements"})
+		return
+	}
+
+	if format == "json" {
+		disagreements, err := models.GetDisagreements(c.Request.Context(), minRaters)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get disagreements"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"transits": disagreements, "ratings": ratings})
+		return
+	}
+
+	writer := startExportTable(c, format, "disagreements", raterExportColumns)
+	defer writer.Flush()
+
+	for _, r := range ratings {
+		writer.Write(append([]string{r.Username}, classificationExportRow(r.ClassificationExport)...))
+	}
+}
+
+// ExportTransitHistory exports every saved version of every user's
+// classification of a transit, oldest first
+func ExportTransitHistory(c *gin.Context) {
+	curve, err := models.GetCurveByFilename(c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	format, err := parseExportFormat(c, "csv", "ecsv", "json")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	versions, err := models.GetClassificationHistory(c.Request.Context(), curve.ID, models.ToDBIndex(index), 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get classification history"})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, versions)
+		return
+	}
+
+	writer := startExportTable(c, format, fmt.Sprintf("history_%s_%d", curve.Filename, index), historyExportColumns)
+	defer writer.Flush()
+
+	for _, v := range versions {
+		row := classificationExportRow(v.ClassificationExport)[:classificationValueColumns]
+		writer.Write(append([]string{v.Username}, row...))
+	}
+}
+
+// ImportUserClassifications accepts a CSV in the export format, either as a
+// multipart "file" field or as the raw request body
+func ImportUserClassifications(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	var body io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file field required"})
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	results, err := models.ImportClassificationsCSV(id, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imported := 0
+	for _, r := range results {
+		if r.Status == models.ImportStatusImported {
+			imported++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": imported,
+		"results":  results,
+	})
+}
+
+func GetUserReportPDF(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	user, err := models.GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	stats, err := models.GetDetailedUserStats(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user stats"})
+		return
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, "Classification progress report", "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 11)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.CellFormat(0, 7, tr(fmt.Sprintf("User: %s (%s)", user.Fullname, user.Username)), "", 1, "L", false, 0, "")
+	lastActivity := stats.LastActivity
+	if lastActivity == "" {
+		lastActivity = "never"
+	}
+	pdf.CellFormat(0, 7, "Last activity: "+lastActivity, "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	writeRows := func(title string, rows [][2]string) {
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.CellFormat(0, 8, title, "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 11)
+		for _, row := range rows {
+			pdf.CellFormat(80, 7, row[0], "1", 0, "L", false, 0, "")
+			pdf.CellFormat(40, 7, row[1], "1", 1, "R", false, 0, "")
+		}
+		pdf.Ln(4)
+	}
+
+	writeRows("Totals", [][2]string{
+		{"Classified transits", fmt.Sprintf("%d / %d", stats.ClassifiedTransits, stats.TotalTransits)},
+		{"Curves with progress", fmt.Sprintf("%d / %d", stats.CurvesWithProgress, stats.TotalCurves)},
+		{"Curves completed", fmt.Sprintf("%d / %d", stats.CurvesCompleted, stats.TotalCurves)},
+	})
+
+	writeRows("Flags", [][2]string{
+		{"Normal transit", strconv.Itoa(stats.NormalTransit)},
+		{"Anomalous morphology", strconv.Itoa(stats.AnomalousMorphology)},
+		{"Left asymmetry", strconv.Itoa(stats.LeftAsymmetry)},
+		{"Right asymmetry", strconv.Itoa(stats.RightAsymmetry)},
+		{"Increased flux", strconv.Itoa(stats.IncreasedFlux)},
+		{"Decreased flux", strconv.Itoa(stats.DecreasedFlux)},
+		{"Marked TDV", strconv.Itoa(stats.MarkedTDV)},
+		{"Bad model fit", strconv.Itoa(stats.BadModelFit)},
+		{"With notes", strconv.Itoa(stats.WithNotes)},
+	})
+
+	// Render fully before answering, so a failure is still a clean 500
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		log.Printf("Error generating report for user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate report"})
+		return
+	}
+
+	c.Header("Content-Disposition", attachmentDisposition(fmt.Sprintf("report_%s.pdf", user.Username)))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// ExportUserPlots streams a ZIP of the plots of every transit the user
+// classified, named <curve>_<transit>. Plots missing from dir are skipped
+// and listed in the archive's manifest.txt.
+func ExportUserPlots(dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+			return
+		}
+
+		user, err := models.GetUserByID(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+			return
+		}
+
+		plots, err := models.GetClassifiedPlots(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get plots"})
+			return
+		}
+
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", attachmentDisposition(fmt.Sprintf("plots_%s.zip", user.Username)))
+
+		// Errors past this point can only be logged, the response has started
+		zw := zip.NewWriter(c.Writer)
+		var manifest strings.Builder
+		fmt.Fprintf(&manifest, "Plots classified by %s\n\n", user.Username)
+		var missing []string
+		for _, p := range plots {
+			name := fmt.Sprintf("%s_%03d%s", p.Filename, p.TransitIndex, filepath.Ext(p.PlotFile))
+			if err := addPlotToZip(zw, filepath.Join(dir, filepath.Base(p.PlotFile)), name); err != nil {
+				if !os.IsNotExist(err) {
+					log.Printf("Error adding plot %s to zip: %v", p.PlotFile, err)
+				}
+				missing = append(missing, fmt.Sprintf("%s (%s)", name, p.PlotFile))
+				continue
+			}
+			fmt.Fprintf(&manifest, "%s\t%s\n", name, p.PlotFile)
+		}
+		if len(missing) > 0 {
+			fmt.Fprintf(&manifest, "\nMissing plots:\n%s\n", strings.Join(missing, "\n"))
+		}
+
+		w, err := zw.Create("manifest.txt")
+		if err == nil {
+			_, err = io.WriteString(w, manifest.String())
+		}
+		if err != nil {
+			log.Printf("Error adding manifest to plots zip for user %d: %v", id, err)
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("Error finishing plots zip for user %d: %v", id, err)
+		}
+	}
+}
+
+// addPlotToZip stores (PNGs are already compressed) one file in the archive
+func addPlotToZip(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Store
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+type TrainingExampleRequest struct {
+	Filename      string   `json:"filename" binding:"required"`
+	TransitIndex  int      `json:"transit_index" binding:"required"`
+	ExpectedFlags []string `json:"expected_flags"`
+}
+
+func ListTrainingExamples(c *gin.Context) {
+	examples, err := models.ListTrainingExamples()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list training examples"})
+		return
+	}
+
+	c.JSON(http.StatusOK, examples)
+}
+
+// SaveTrainingExample marks a transit as a training example with known
+// flags, replacing the expected flags if it already is one
+func SaveTrainingExample(c *gin.Context) {
+	var req TrainingExampleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	flags := []string{}
+	seen := map[string]bool{}
+	for _, name := range req.ExpectedFlags {
+		flag := models.ResolveFlag(name)
+		if flag == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown flag: " + name})
+			return
+		}
+		if !seen[flag] {
+			seen[flag] = true
+			flags = append(flags, flag)
+		}
+	}
+
+	transit := models.GetTransit(req.Filename, req.TransitIndex)
+	if transit == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
+		return
+	}
+
+	id, err := models.SaveTrainingExample(transit.CurveID, models.ToDBIndex(req.TransitIndex), flags, middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save training example"})
+		return
+	}
+
+	example, err := models.GetTrainingExample(id)
+	if err != nil || example == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get training example"})
+		return
+	}
+
+	c.JSON(http.StatusOK, example)
+}
+
+func DeleteTrainingExample(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid training example ID"})
+		return
+	}
+
+	deleted, err := models.DeleteTrainingExample(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete training example"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Training example not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Training example deleted"})
+}
+
+func DeleteUserCurveClassifications(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	curveID, err := strconv.ParseInt(c.Param("curveId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	deleted, err := models.DeleteCurveClassifications(curveID, id, true)
+	if err != nil {
+		log.Printf("Error deleting classifications: curve_id=%d, user_id=%d, error=%v", curveID, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete classifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// DeleteUserClassification removes one user's classification of a transit,
+// locked or not
+func DeleteUserClassification(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_user_id")})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "user_not_found")})
+		return
+	}
+
+	curve, err := models.GetCurveByFilename(c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	dbIndex := models.ToDBIndex(index)
+	deleted, err := models.DeleteClassification(curve.ID, dbIndex, id, true)
+	if err != nil {
+		log.Printf("Error deleting classification: curve_id=%d, transit_index=%d, user_id=%d, error=%v", curve.ID, dbIndex, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete classification"})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "classification_not_found")})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+const (
+	eventsInterval    = 5 * time.Second
+	activeUsersWindow = 5 * time.Minute
+)
+
+func StreamEvents(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(eventsInterval)
+	defer ticker.Stop()
+
+	sendSnapshot := func() {
+		snapshot, err := models.GetActivitySnapshot(activeUsersWindow)
+		if err != nil {
+			log.Printf("Error getting activity snapshot: %v", err)
+			c.SSEvent("error", gin.H{"error": "Failed to get activity snapshot"})
+			return
+		}
+		c.SSEvent("snapshot", snapshot)
+	}
+
+	sendSnapshot()
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			sendSnapshot()
+			return true
+		}
+	})
+}
+
+func ReloadData(curvesCsvPath, transitsCsvPath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := models.ReloadFromCSV(curvesCsvPath, transitsCsvPath)
+		if errors.Is(err, models.ErrImportInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Import already in progress"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error reloading data: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import data"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Import completed",
+			"files":   len(models.GetAllFiles()),
+		})
+	}
+}
+
+func GetCurveFlagHeatmap(c *gin.Context) {
+	afterID, err := strconv.ParseInt(c.DefaultQuery("after_id", "0"), 10, 64)
+	if err != nil || afterID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after_id"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	rows, err := models.GetCurveFlagHeatmap(afterID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get heatmap"})
+		return
+	}
+
+	// Results are ordered by curve id, so the last one is the cursor for the next page
+	nextAfterID := afterID
+	if len(rows) > 0 {
+		nextAfterID = rows[len(rows)-1].CurveID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"curves":        rows,
+		"next_after_id": nextAfterID,
+	})
+}
+
+func GetClassificationsChangedSince(c *gin.Context) {
+	since, _, err := parseExportTime(c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since"})
+		return
+	}
+
+	afterID, err := strconv.ParseInt(c.DefaultQuery("after_id", "0"), 10, 64)
+	if err != nil || afterID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after_id"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit < 1 || limit > 5000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	classifications, hasMore, err := models.GetClassificationsChangedSince(since, afterID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
+
+	// Poll again with since=next_since&after_id=next_after_id; both are
+	// unchanged when nothing new arrived
+	nextSince, nextAfterID := since.UTC(), afterID
+	if n := len(classifications); n > 0 && classifications[n-1].Timestamp != nil {
+		nextSince = classifications[n-1].Timestamp.UTC()
+		nextAfterID = classifications[n-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"classifications": classifications,
+		"next_since":      nextSince.Format(time.RFC3339),
+		"next_after_id":   nextAfterID,
+		"has_more":        hasMore,
+	})
+}
+
+func GetContentiousTransits(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	transits, err := models.GetContentiousTransits(limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get contentious transits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transits)
+}
+
+func SearchNotes(c *gin.Context) {
+	term := strings.TrimSpace(c.Query("q"))
+	if term == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search term q is required"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > models.MaxNoteSearchResults {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	matches, err := models.SearchNotes(term, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search notes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, matches)
+}
+
+func GetEmptyCurves(c *gin.Context) {
+	curves, err := models.GetEmptyCurves()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get curves"})
+		return
+	}
+
+	c.JSON(http.StatusOK, curves)
+}
+
+func GetEmptyClassifications(c *gin.Context) {
+	classifications, err := models.GetEmptyClassifications()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
+
+	c.JSON(http.StatusOK, classifications)
+}
+
+func GetOrphanClassifications(c *gin.Context) {
+	classifications, err := models.FindOrphanClassifications()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
+
+	c.JSON(http.StatusOK, classifications)
+}
+
+func PurgeOrphanClassifications(c *gin.Context) {
+	if c.Query("confirm") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Purging requires confirm=true"})
+		return
+	}
+
+	deleted, err := models.DeleteOrphanClassifications()
+	if errors.Is(err, models.ErrImportInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import in progress, try again later"})
+		return
+	}
+	if errors.Is(err, models.ErrNoTransits) {
+		c.JSON(http.StatusConflict, gin.H{"error": "No transits loaded, refusing to purge"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge classifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func GetReviewFlags(c *gin.Context) {
+	flags, err := models.GetReviewFlags()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get flagged transits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, flags)
+}
+
+func ListClassificationsAfter(c *gin.Context) {
+	afterID, err := strconv.ParseInt(c.DefaultQuery("after_id", "0"), 10, 64)
+	if err != nil || afterID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after_id"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit < 1 || limit > 5000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	classifications, err := models.GetClassificationsAfter(afterID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
+
+	// Results are ordered by id, so the last one is the cursor for the next page
+	nextAfterID := afterID
+	if len(classifications) > 0 {
+		nextAfterID = classifications[len(classifications)-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"classifications": classifications,
+		"next_after_id":   nextAfterID,
+	})
+}
+
+type PlotManifestEntry struct {
+	PlotFile string `json:"plot_file"`
+	URL      string `json:"url"`
+}
+
+func GetPlotManifest(c *gin.Context) {
+	after := c.Query("after")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit < 1 || limit > 10000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	files, err := models.GetAllPlotFiles(after, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get plot files"})
+		return
+	}
+
+	entries := make([]PlotManifestEntry, len(files))
+	for i, f := range files {
+		entries[i] = PlotManifestEntry{PlotFile: f, URL: "/api/plots/" + url.PathEscape(f)}
+	}
+
+	// Results are ordered by name, so the last one is the cursor for the next page
+	nextAfter := after
+	if len(files) > 0 {
+		nextAfter = files[len(files)-1]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plot_files": entries,
+		"next_after": nextAfter,
+	})
+}
+
+type BulkAssignRequest struct {
+	CurveIDs []int64 `json:"curve_ids" binding:"required,min=1"`
+	UserIDs  []int64 `json:"user_ids" binding:"required,min=1"`
+	Strategy string  `json:"strategy"`
+}
+
+func BulkAssignCurves(c *gin.Context) {
+	var req BulkAssignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Strategy == "" {
+		req.Strategy = models.AssignRoundRobin
+	}
+
+	counts, err := models.BulkAssignCurves(req.CurveIDs, req.UserIDs, req.Strategy)
+	switch {
+	case errors.Is(err, models.ErrInvalidAssignStrategy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strategy must be round_robin or all"})
+		return
+	case errors.Is(err, models.ErrUnknownCurve):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown curve ID"})
+		return
+	case errors.Is(err, models.ErrUnknownUser):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user ID"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign curves"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+func GetTransitDiscrepancies(c *gin.Context) {
+	threshold := 0
+	if v := c.Query("threshold"); v != "" {
+		t, err := strconv.Atoi(v)
+		if err != nil || t < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+			return
+		}
+		threshold = t
+	}
+
+	discrepancies, err := models.GetTransitDiscrepancies(threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transit discrepancies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, discrepancies)
+}
+
+func boolToStr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func floatPtrToStr(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+type CurveNotesRequest struct {
+	Notes string `json:"notes"`
+}
+
+func GetCurveRaters(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	raters, err := models.GetCurveRaters(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get curve raters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, raters)
+}
+
+func SetCurveNotes(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	var req CurveNotesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	if err := models.SetCurveNotes(id, req.Notes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save curve notes"})
+		return
+	}
+
+	curve, err := models.GetCurveByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get curve"})
+		return
+	}
+
+	c.JSON(http.StatusOK, curve)
+}
+
+// TriggerBackup writes a database backup on demand; dir is empty when
+// backups are not configured
+func TriggerBackup(dir string, keep int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if dir == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Backups are not configured"})
+			return
+		}
+
+		path, err := db.Backup(dir, keep)
+		if err != nil {
+			log.Printf("On-demand backup failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up database"})
+			return
+		}
+		log.Printf("Database backed up to %s", path)
+
+		c.JSON(http.StatusOK, gin.H{"path": path})
 	}
-	return strconv.FormatFloat(*f, 'f', -1, 64)
 }
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"emoons-web/db/dbtest"
+	"emoons-web/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExportUserPlots(t *testing.T) {
+	setupTestDB(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a_1.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	user, token := createTestUser(t, `o'brien "smith"`, models.RoleAdmin)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a_1.png'), (1, 2, 'a_2.png')`)
+	dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit) VALUES (1, 0, ?, 1), (1, 1, ?, 1)`,
+		user.ID, user.ID)
+
+	r := gin.New()
+	r.GET("/users/:id/plots.zip", ExportUserPlots(dir))
+	w := serve(r, http.MethodGet, fmt.Sprintf("/users/%d/plots.zip", user.ID), token, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+	if err != nil {
+		t.Fatalf("bad Content-Disposition %q: %v", w.Header().Get("Content-Disposition"), err)
+	}
+	if want := `plots_o'brien "smith".zip`; params["filename"] != want {
+		t.Errorf("filename = %q, want %q", params["filename"], want)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	if len(files) != 2 || files["curveA_001.png"] != "png" {
+		t.Fatalf("archive holds %v, want curveA_001.png and manifest.txt", files)
+	}
+	if manifest := files["manifest.txt"]; !strings.Contains(manifest, "Missing plots:\ncurveA_002.png (a_2.png)") {
+		t.Errorf("manifest does not list the missing plot:\n%s", manifest)
+	}
+}
+
+func TestGetPlotManifestLinksAuthenticatedRoute(t *testing.T) {
+	setupTestDB(t)
+	_, token := createTestUser(t, "root", models.RoleAdmin)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a 1.png')`)
+
+	r := gin.New()
+	r.GET("/plots/manifest", GetPlotManifest)
+	w := serve(r, http.MethodGet, "/plots/manifest", token, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		PlotFiles []PlotManifestEntry `json:"plot_files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []PlotManifestEntry{{PlotFile: "a 1.png", URL: "/api/plots/a%201.png"}}
+	if !reflect.DeepEqual(body.PlotFiles, want) {
+		t.Errorf("got %+v, want %+v", body.PlotFiles, want)
+	}
+}
+
+func TestClassificationExportColumnsMatchRows(t *testing.T) {
+	row := classificationExportRow(models.ClassificationExport{})
+	if len(row) != len(classificationExportColumns) {
+		t.Errorf("export row has %d fields, header has %d columns", len(row), len(classificationExportColumns))
+	}
+	if classificationValueColumns <= 0 ||
+		classificationExportColumns[classificationValueColumns-1].name != "timestamp" {
+		t.Errorf("value columns end at %d, want just after timestamp", classificationValueColumns)
+	}
+	// History rows are the username plus the value columns, with the timestamp as version_at
+	if len(historyExportColumns) != 1+classificationValueColumns {
+		t.Errorf("history header has %d columns, rows have %d", len(historyExportColumns), 1+classificationValueColumns)
+	}
+}
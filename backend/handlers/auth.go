@@ -32,12 +32,12 @@ func Login(c *gin.Context) {
 	user, err := models.GetUserByUsername(req.Username)
 	if err != nil {
 		log.Printf("Login: error getting user: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg(c, "invalid_credentials")})
 		return
 	}
 	if user == nil {
 		log.Printf("Login: user not found: %s", req.Username)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg(c, "invalid_credentials")})
 		return
 	}
 
@@ -45,7 +45,14 @@ func Login(c *gin.Context) {
 
 	if !user.CheckPassword(req.Password) {
 		log.Printf("Login: password mismatch for user %s", req.Username)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg(c, "invalid_credentials")})
+		return
+	}
+
+	// Checked after the password so disabled accounts aren't revealed to guessers
+	if !user.Active {
+		log.Printf("Login: account disabled for user %s", user.Username)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled", "code": "account_disabled"})
 		return
 	}
 
@@ -61,7 +68,32 @@ func Login(c *gin.Context) {
 	})
 }
 
+var guestUser = models.User{
+	Username: "guest",
+	Fullname: "Guest",
+	IsGuest:  true,
+}
+
+func GuestLogin(c *gin.Context) {
+	token, err := middleware.GenerateGuestToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	user := guestUser
+	c.JSON(http.StatusOK, LoginResponse{
+		Token: token,
+		User:  &user,
+	})
+}
+
 func GetMe(c *gin.Context) {
+	if middleware.GetIsGuest(c) {
+		c.JSON(http.StatusOK, guestUser)
+		return
+	}
+
 	userID := middleware.GetUserID(c)
 	user, err := models.GetUserByID(userID)
 	if err != nil {
@@ -73,6 +105,12 @@ func GetMe(c *gin.Context) {
 }
 
 func Logout(c *gin.Context) {
-	// JWT is stateless, so logout is handled client-side by removing the token
+	// The client discards the token; dropping the session also invalidates it
+	// server-side when the idle timeout is enforced
+	if sessionID := middleware.GetSessionID(c); sessionID != "" {
+		if err := models.DeleteSession(sessionID); err != nil {
+			log.Printf("Logout: failed to delete session %s: %v", sessionID, err)
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
 }
@@ -3,9 +3,13 @@ package handlers
 import (
 	"emoons-web/middleware"
 	"emoons-web/models"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,27 +17,35 @@ import (
 func GetClassification(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	filename := c.Param("file")
-	indexStr := c.Param("index")
-
-	index, err := strconv.Atoi(indexStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transit index"})
-		return
-	}
 
 	// Get curve by filename to find curve_id
 	curve, err := models.GetCurveByFilename(filename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find curve"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
 		return
 	}
 	if curve == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Curve not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
 		return
 	}
 
-	// Convert from 1-indexed (CSV/UI) to 0-indexed (database)
-	dbIndex := index - 1
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	defaultSource := c.Query("default")
+	if defaultSource != "" && defaultSource != "consensus" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "default must be 'consensus'"})
+		return
+	}
+	// Showing raters the majority answer would bias the votes it tallies
+	if defaultSource == "consensus" && !middleware.HasRole(c, models.RoleReviewer) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	dbIndex := models.ToDBIndex(index)
 	classification, err := models.GetClassification(curve.ID, dbIndex, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get classification"})
@@ -41,6 +53,22 @@ func GetClassification(c *gin.Context) {
 	}
 
 	if classification == nil {
+		// ?default=consensus prefills an unsaved form with the majority answers
+		if defaultSource == "consensus" {
+			consensus, err := models.GetTransitConsensus(curve.ID, dbIndex)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get consensus"})
+				return
+			}
+			if consensus != nil {
+				c.JSON(http.StatusOK, ClassificationDefaults{
+					Classification: consensus.DefaultClassification(),
+					Default:        defaultSource,
+					Raters:         consensus.Raters,
+				})
+				return
+			}
+		}
 		c.JSON(http.StatusOK, nil)
 		return
 	}
@@ -48,45 +76,51 @@ func GetClassification(c *gin.Context) {
 	c.JSON(http.StatusOK, classification)
 }
 
+// ClassificationDefaults is a prefilled classification that hasn't been saved
+type ClassificationDefaults struct {
+	*models.Classification
+	Default string `json:"default"`
+	Raters  int    `json:"raters"`
+}
+
 func SaveClassification(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	filename := c.Param("file")
-	indexStr := c.Param("index")
 
-	index, err := strconv.Atoi(indexStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transit index"})
+	var input models.ClassificationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_request_body")})
 		return
 	}
 
-	var input models.ClassificationInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	// Clearing a classification goes through DeleteClassification instead
+	if err := models.ValidateClassification(input); err != nil {
+		message := "Classification must set at least one flag or include notes or tags"
+		if errors.Is(err, models.ErrAnomalyNeedsNotes) {
+			message = "Anomalous morphology requires notes explaining it"
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": message})
 		return
 	}
 
 	// Get curve by filename to find curve_id
 	curve, err := models.GetCurveByFilename(filename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find curve"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
 		return
 	}
 	if curve == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Curve not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
 		return
 	}
 
-	// If all fields are empty, delete existing classification instead of saving
-	if !input.LeftAsymmetry && !input.RightAsymmetry &&
-		!input.IncreasedFlux && !input.DecreasedFlux &&
-		!input.NormalTransit && !input.AnomalousMorphology &&
-		!input.MarkedTDV && !input.BadModelFit &&
-		input.Notes == "" {
-		_ = models.DeleteClassification(curve.ID, index-1, userID)
-		c.JSON(http.StatusOK, gin.H{"message": "Empty classification removed"})
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
 		return
 	}
 
+	dbIndex := models.ToDBIndex(index)
+
 	// Get transit data from CSV to fill in timing info
 	transit := models.GetTransit(filename, index)
 	if transit != nil {
@@ -95,9 +129,17 @@ func SaveClassification(c *gin.Context) {
 		input.TTVMinutes = transit.TTVMinutes
 	}
 
-	// Convert from 1-indexed (CSV/UI) to 0-indexed (database)
-	dbIndex := index - 1
-	err = models.SaveClassification(curve.ID, dbIndex, userID, input)
+	// Quotas cap new classifications only, updates are always allowed.
+	// Locked classifications can only be changed by reviewers.
+	err = models.SaveClassification(curve.ID, dbIndex, userID, input, middleware.HasRole(c, models.RoleReviewer))
+	if errors.Is(err, models.ErrQuotaReached) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Classification quota reached"})
+		return
+	}
+	if errors.Is(err, models.ErrClassificationLocked) {
+		c.JSON(http.StatusConflict, gin.H{"error": errMsg(c, "classification_locked")})
+		return
+	}
 	if err != nil {
 		log.Printf("Error saving classification: curve_id=%d, index=%d, dbIndex=%d, user_id=%d, error=%v",
 			curve.ID, index, dbIndex, userID, err)
@@ -108,10 +150,254 @@ func SaveClassification(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Classification saved"})
 }
 
+func DeleteClassification(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	filename := c.Param("file")
+
+	curve, err := models.GetCurveByFilename(filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	dbIndex := models.ToDBIndex(index)
+
+	// Locked classifications can only be removed by reviewers
+	_, err = models.DeleteClassification(curve.ID, dbIndex, userID, middleware.HasRole(c, models.RoleReviewer))
+	if errors.Is(err, models.ErrClassificationLocked) {
+		c.JSON(http.StatusConflict, gin.H{"error": errMsg(c, "classification_locked")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete classification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Classification removed"})
+}
+
+func GetFinalClassification(c *gin.Context) {
+	curve, err := models.GetCurveByFilename(c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	final, err := models.GetFinalClassification(curve.ID, models.ToDBIndex(index))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get final classification"})
+		return
+	}
+	if final == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transit has no final classification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, final)
+}
+
+// SetFinalClassification records a reviewer's adjudicated answer for a transit
+func SetFinalClassification(c *gin.Context) {
+	var input models.ClassificationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_request_body")})
+		return
+	}
+
+	curve, err := models.GetCurveByFilename(c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	dbIndex := models.ToDBIndex(index)
+	if err := models.SaveFinalClassification(curve.ID, dbIndex, middleware.GetUserID(c), input); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save final classification"})
+		return
+	}
+
+	final, err := models.GetFinalClassification(curve.ID, dbIndex)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get final classification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, final)
+}
+
+type ReviewFlagRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// FlagTransitForReview lets a classifier escalate a transit to the admins
+func FlagTransitForReview(c *gin.Context) {
+	var req ReviewFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+
+	curve, err := models.GetCurveByFilename(c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	err = models.FlagTransitForReview(curve.ID, models.ToDBIndex(index), middleware.GetUserID(c), strings.TrimSpace(req.Reason))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flag transit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transit flagged for review"})
+}
+
+func GetTaggedClassifications(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	tag := c.Query("tag")
+	if tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing tag parameter"})
+		return
+	}
+
+	transits, err := models.GetTransitsByTag(userID, tag)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
+
+	c.JSON(http.StatusOK, transits)
+}
+
+func SearchClassifications(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var flags []string
+	for _, name := range strings.Split(c.Query("flags"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		flag := models.ResolveFlag(name)
+		if flag == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown flag: " + name})
+			return
+		}
+		flags = append(flags, flag)
+	}
+	if len(flags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing flags parameter"})
+		return
+	}
+
+	var matchAll bool
+	switch c.DefaultQuery("match", "all") {
+	case "all":
+		matchAll = true
+	case "any":
+		matchAll = false
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "match must be 'all' or 'any'"})
+		return
+	}
+
+	results, err := models.SearchClassifications(userID, flags, matchAll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search classifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+func GetVsConsensus(c *gin.Context) {
+	minRaters, err := strconv.Atoi(c.DefaultQuery("min_raters", "3"))
+	if err != nil || minRaters < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_raters"})
+		return
+	}
+
+	divergences, err := models.GetUserVsConsensus(middleware.GetUserID(c), minRaters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare with consensus"})
+		return
+	}
+
+	c.JSON(http.StatusOK, divergences)
+}
+
+func GetCalibration(c *gin.Context) {
+	minRaters, err := strconv.Atoi(c.DefaultQuery("min_raters", "3"))
+	if err != nil || minRaters < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_raters"})
+		return
+	}
+
+	calibration, err := models.GetUserCalibration(middleware.GetUserID(c), minRaters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute calibration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, calibration)
+}
+
+func GetConsensusAgreement(c *gin.Context) {
+	minRaters, err := strconv.Atoi(c.DefaultQuery("min_raters", "3"))
+	if err != nil || minRaters < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_raters"})
+		return
+	}
+
+	agreement, err := models.GetUserConsensusAgreement(middleware.GetUserID(c), minRaters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare with consensus"})
+		return
+	}
+
+	c.JSON(http.StatusOK, agreement)
+}
+
 func GetStats(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 
-	stats, err := models.GetUserStats(userID)
+	stats, err := models.GetUserStats(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
 		return
@@ -120,17 +406,159 @@ func GetStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+func GetProjectProgress(c *gin.Context) {
+	progress, err := models.GetProjectProgress(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// GetRatersRemaining reports how far transits are from the project's
+// minRaters target; ?by_curve=true adds the per-curve breakdown
+func GetRatersRemaining(minRaters int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deficit, err := models.GetRaterDeficit(minRaters)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rater deficit"})
+			return
+		}
+		if c.Query("by_curve") != "true" {
+			deficit.Curves = nil
+		}
+
+		c.JSON(http.StatusOK, deficit)
+	}
+}
+
+func GetStreak(loc *time.Location) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streak, err := models.GetUserStreak(middleware.GetUserID(c), loc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get streak"})
+			return
+		}
+
+		c.JSON(http.StatusOK, streak)
+	}
+}
+
+func GetStatsByDataType(c *gin.Context) {
+	var counts []models.DataTypeCount
+	var err error
+	if c.Query("all") == "true" {
+		if !middleware.GetIsAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		counts, err = models.GetAllClassificationsByDataType(c.Request.Context())
+	} else {
+		counts, err = models.GetClassificationsByDataType(c.Request.Context(), middleware.GetUserID(c))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+func GetFlagCoOccurrence(c *gin.Context) {
+	var result *models.FlagCoOccurrence
+	var err error
+	if c.Query("all") == "true" {
+		if !middleware.GetIsAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		result, err = models.GetAllFlagCoOccurrence(c.Request.Context())
+	} else {
+		result, err = models.GetFlagCoOccurrence(c.Request.Context(), middleware.GetUserID(c))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get flag co-occurrence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func GetFlagRates(c *gin.Context) {
+	var result map[string]float64
+	var err error
+	if c.Query("all") == "true" {
+		if !middleware.GetIsAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		result, err = models.GetAllFlagRates(c.Request.Context())
+	} else {
+		result, err = models.GetFlagRates(c.Request.Context(), middleware.GetUserID(c))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get flag rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func GetCurveClassificationsForIndices(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	curveID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	parts := strings.Split(c.Query("indices"), ",")
+	if len(parts) > models.MaxClassificationIndices {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d indices allowed", models.MaxClassificationIndices)})
+		return
+	}
+
+	var indices []int
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		index, err := strconv.Atoi(part)
+		if err != nil || index < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transit index: " + part})
+			return
+		}
+		indices = append(indices, models.ToDBIndex(index))
+	}
+	if len(indices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "indices parameter required"})
+		return
+	}
+
+	classifications, err := models.GetClassificationsForIndices(curveID, userID, indices)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_get_classifications")})
+		return
+	}
+
+	c.JSON(http.StatusOK, classifications)
+}
+
 func DeleteCurveClassifications(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	curveIDStr := c.Param("id")
 
 	curveID, err := strconv.ParseInt(curveIDStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid curve ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
 		return
 	}
 
-	deleted, err := models.DeleteCurveClassifications(curveID, userID)
+	// Locked classifications were signed off by a reviewer and are kept
+	deleted, err := models.DeleteCurveClassifications(curveID, userID, false)
 	if err != nil {
 		log.Printf("Error deleting classifications: curve_id=%d, user_id=%d, error=%v", curveID, userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete classifications"})
@@ -139,3 +567,38 @@ func DeleteCurveClassifications(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
 }
+
+func LockTransit(c *gin.Context) {
+	setTransitLocked(c, true)
+}
+
+func UnlockTransit(c *gin.Context) {
+	setTransitLocked(c, false)
+}
+
+func setTransitLocked(c *gin.Context, locked bool) {
+	filename := c.Param("file")
+
+	curve, err := models.GetCurveByFilename(filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	updated, err := models.SetTransitLocked(curve.ID, models.ToDBIndex(index), locked)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"locked": locked, "updated": updated})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"emoons-web/db/dbtest"
+	"emoons-web/middleware"
+	"emoons-web/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newClassificationRouter wires the classification routes as main does
+func newClassificationRouter() *gin.Engine {
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middleware.AuthRequired(), middleware.GuestReadOnly())
+	api.GET("/transits/:file/:index/classify", GetClassification)
+	api.POST("/transits/:file/:index/classify", SaveClassification)
+	api.DELETE("/transits/:file/:index/classify", DeleteClassification)
+	api.DELETE("/curves/:id/classifications", DeleteCurveClassifications)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AdminRequired(), middleware.AuditLog())
+	admin.DELETE("/users/:id/curves/:curveId/classifications", DeleteUserCurveClassifications)
+	admin.DELETE("/users/:id/transits/:file/:index/classify", DeleteUserClassification)
+	return r
+}
+
+func TestUsersCannotTouchOtherUsersClassifications(t *testing.T) {
+	setupTestDB(t)
+	r := newClassificationRouter()
+
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a_1.png'), (1, 2, 'a_2.png')`)
+	alice, aliceToken := createTestUser(t, "alice", models.RoleUser)
+	bob, bobToken := createTestUser(t, "bob", models.RoleUser)
+	_, adminToken := createTestUser(t, "root", models.RoleAdmin)
+
+	for _, index := range []int{1, 2} {
+		path := fmt.Sprintf("/api/transits/curveA/%d/classify", index)
+		if w := serve(r, http.MethodPost, path, bobToken, `{"normal_transit": true}`); w.Code != http.StatusOK {
+			t.Fatalf("bob saving transit %d: status %d: %s", index, w.Code, w.Body)
+		}
+	}
+
+	// Alice's own writes and deletes only ever reach her rows
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/transits/curveA/1/classify", `{"left_asymmetry": true}`},
+		{http.MethodDelete, "/api/transits/curveA/1/classify", ""},
+		{http.MethodDelete, "/api/transits/curveA/2/classify", ""},
+		{http.MethodDelete, "/api/curves/1/classifications", ""},
+	}
+	for _, req := range requests {
+		serve(r, req.method, req.path, aliceToken, req.body)
+		if n := countUserClassifications(t, bob.ID); n != 2 {
+			t.Fatalf("after alice's %s %s: bob has %d classifications, want 2", req.method, req.path, n)
+		}
+	}
+
+	// Reading returns her own (now deleted) classification, not bob's
+	if w := serve(r, http.MethodGet, "/api/transits/curveA/2/classify", aliceToken, ""); w.Body.String() != "null" {
+		t.Errorf("alice reading transit 2: got %s, want null", w.Body)
+	}
+
+	// The admin routes that name a user are closed to regular users
+	adminPaths := []string{
+		fmt.Sprintf("/api/admin/users/%d/curves/1/classifications", bob.ID),
+		fmt.Sprintf("/api/admin/users/%d/transits/curveA/1/classify", bob.ID),
+	}
+	for _, path := range adminPaths {
+		if w := serve(r, http.MethodDelete, path, aliceToken, ""); w.Code != http.StatusForbidden {
+			t.Errorf("alice DELETE %s: status %d, want 403", path, w.Code)
+		}
+	}
+	if n := countUserClassifications(t, bob.ID); n != 2 {
+		t.Fatalf("after alice's admin requests: bob has %d classifications, want 2", n)
+	}
+
+	// An admin deletes exactly the chosen user's rows
+	if w := serve(r, http.MethodPost, "/api/transits/curveA/1/classify", aliceToken, `{"notes": "keep"}`); w.Code != http.StatusOK {
+		t.Fatalf("alice saving: status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodDelete, adminPaths[0], adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("admin DELETE %s: status %d: %s", adminPaths[0], w.Code, w.Body)
+	}
+	if n := countUserClassifications(t, bob.ID); n != 0 {
+		t.Errorf("after admin delete: bob has %d classifications, want 0", n)
+	}
+	if n := countUserClassifications(t, alice.ID); n != 1 {
+		t.Errorf("after admin delete of bob's rows: alice has %d classifications, want 1", n)
+	}
+}
+
+func TestLockedClassificationNeedsReviewer(t *testing.T) {
+	setupTestDB(t)
+	r := newClassificationRouter()
+
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a_1.png')`)
+	_, userToken := createTestUser(t, "alice", models.RoleUser)
+	_, reviewerToken := createTestUser(t, "rev", models.RoleReviewer)
+
+	path := "/api/transits/curveA/1/classify"
+	for _, token := range []string{userToken, reviewerToken} {
+		if w := serve(r, http.MethodPost, path, token, `{"normal_transit": true}`); w.Code != http.StatusOK {
+			t.Fatalf("saving: status %d: %s", w.Code, w.Body)
+		}
+	}
+	if _, err := models.SetTransitLocked(1, models.ToDBIndex(1), true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		if w := serve(r, method, path, userToken, `{"notes": "changed"}`); w.Code != http.StatusConflict {
+			t.Errorf("user %s on a locked classification: status %d, want 409", method, w.Code)
+		}
+		if w := serve(r, method, path, reviewerToken, `{"notes": "changed"}`); w.Code != http.StatusOK {
+			t.Errorf("reviewer %s on a locked classification: status %d, want 200: %s", method, w.Code, w.Body)
+		}
+	}
+}
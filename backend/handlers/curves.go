@@ -21,41 +21,321 @@ func GetCurves(c *gin.Context) {
 	c.JSON(http.StatusOK, curves)
 }
 
+// GetPriorityCurves lists the curves where the user's ratings are most
+// needed to reach minRaters per transit
+func GetPriorityCurves(minRaters int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+			return
+		}
+
+		curves, err := models.GetPriorityCurves(middleware.GetUserID(c), minRaters, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get curves"})
+			return
+		}
+
+		c.JSON(http.StatusOK, curves)
+	}
+}
+
 func GetCurve(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid curve ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
 		return
 	}
 
 	curve, err := models.GetCurveByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Curve not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
 		return
 	}
 
-	c.JSON(http.StatusOK, curve)
+	verdict, err := models.GetCurveVerdict(curve.ID, middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get curve verdict"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CurveResponse{Curve: curve, Verdict: verdict})
+}
+
+type ConsensusResponse struct {
+	models.TransitConsensus
+	Consensus map[string]*bool `json:"consensus"`
+}
+
+// GetCurveConsensus returns the per-transit majority of a curve;
+// ?weighted=true weights raters by their agreement with the final
+// classifications
+func GetCurveConsensus(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	minRaters, err := strconv.Atoi(c.DefaultQuery("min_raters", "3"))
+	if err != nil || minRaters < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_raters"})
+		return
+	}
+
+	curve, err := models.GetCurveByID(id)
+	if err != nil || curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	consensus, err := models.GetConsensusForCurve(curve.ID, minRaters, c.Query("weighted") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get consensus"})
+		return
+	}
+
+	response := make([]ConsensusResponse, len(consensus))
+	for i, t := range consensus {
+		response[i] = ConsensusResponse{TransitConsensus: t, Consensus: t.Decisions()}
+		response[i].TransitIndex = models.ToUIIndex(t.TransitIndex)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CurveResponse is a curve plus the requesting user's whole-curve verdict
+type CurveResponse struct {
+	*models.Curve
+	Verdict *models.CurveVerdict `json:"verdict"`
+}
+
+type CurveVerdictRequest struct {
+	Verdict string `json:"verdict"`
+	Notes   string `json:"notes"`
+}
+
+func SaveCurveVerdict(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	var req CurveVerdictRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !models.IsValidCurveVerdict(req.Verdict) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verdict"})
+		return
+	}
+
+	curve, err := models.GetCurveByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	if err := models.SaveCurveVerdict(curve.ID, middleware.GetUserID(c), req.Verdict, req.Notes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save verdict"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verdict saved"})
 }
 
 func GetCurveTransits(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid curve ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
 		return
 	}
 
 	curve, err := models.GetCurveByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Curve not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	if c.Query("with_classifications") == "true" {
+		userID := middleware.GetUserID(c)
+		transits, err := models.GetTransitsWithClassifications(curve.ID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transits"})
+			return
+		}
+		c.JSON(http.StatusOK, transits)
+		return
+	}
+
+	fitted, ok := parseFittedFilter(c)
+	if !ok {
 		return
 	}
 
-	transits := models.GetTransitsForFile(curve.Filename)
+	transits := models.GetTransitsForFile(curve.Filename, fitted)
 	if transits == nil {
 		transits = []models.Transit{}
 	}
 
 	c.JSON(http.StatusOK, transits)
 }
+
+func GetCurveRaterCounts(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	counts, err := models.GetRaterCountsForCurve(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rater counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// GetCurveNotes lists the caller's notes on a curve, or everyone's for reviewers and admins
+func GetCurveNotes(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	includeAll := middleware.HasRole(c, models.RoleReviewer)
+	notes, err := models.GetNotesForCurve(id, middleware.GetUserID(c), includeAll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, notes)
+}
+
+func CompleteCurve(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	if err := models.CompleteCurve(id, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark curve complete"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Curve marked complete"})
+}
+
+func UncompleteCurve(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	found, err := models.UncompleteCurve(id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reopen curve"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Curve not marked complete"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Curve reopened"})
+}
+
+func GetTTVOutliers(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	sigma, err := strconv.ParseFloat(c.DefaultQuery("sigma", "3"), 64)
+	if err != nil || sigma <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sigma"})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	report, err := models.GetTTVOutliers(id, sigma)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get TTV outliers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func GetDurationAnomalies(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_curve_id")})
+		return
+	}
+
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "10"), 64)
+	if err != nil || threshold < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+		return
+	}
+
+	if _, err := models.GetCurveByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	anomalies, err := models.GetDurationAnomalies(id, threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get duration anomalies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, anomalies)
+}
+
+// ClaimNextCurve hands the caller the next curve nobody is assigned to
+func ClaimNextCurve(c *gin.Context) {
+	curve, err := models.ClaimNextCurve(middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim curve"})
+		return
+	}
+	if curve == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, curve)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"emoons-web/db/dbtest"
+	"emoons-web/middleware"
+	"emoons-web/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetCurveConsensus(t *testing.T) {
+	setupTestDB(t)
+	r := gin.New()
+	r.GET("/api/curves/:id/consensus", middleware.AuthRequired(),
+		middleware.RoleRequired(models.RoleReviewer), GetCurveConsensus)
+
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	var raters []int64
+	for _, name := range []string{"ann", "ben", "cat"} {
+		user, _ := createTestUser(t, name, models.RoleUser)
+		raters = append(raters, user.ID)
+	}
+	_, token := createTestUser(t, "rev", models.RoleReviewer)
+	for i, id := range raters {
+		dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit) VALUES (1, ?, ?, ?)`,
+			models.ToDBIndex(2), id, i < 2)
+	}
+
+	w := serve(r, http.MethodGet, "/api/curves/1/consensus", token, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var got []struct {
+		TransitIndex int              `json:"transit_index"`
+		Raters       int              `json:"raters"`
+		Consensus    map[string]*bool `json:"consensus"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].TransitIndex != 2 || got[0].Raters != 3 {
+		t.Fatalf("got %+v, want transit 2 with 3 raters", got)
+	}
+	if normal := got[0].Consensus["normal_transit"]; normal == nil || !*normal {
+		t.Errorf("normal_transit consensus %v, want true", normal)
+	}
+
+	if w := serve(r, http.MethodGet, "/api/curves/99/consensus", token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing curve: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportColumn describes one export column; the CSV header and the ECSV
+// datatype block are both generated from it
+type exportColumn struct {
+	name        string
+	datatype    string
+	unit        string
+	description string
+}
+
+func exportColumnNames(columns []exportColumn) []string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.name
+	}
+	return names
+}
+
+func parseExportFormat(c *gin.Context, allowed ...string) (string, error) {
+	format := c.DefaultQuery("format", "csv")
+	for _, f := range allowed {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("format must be one of %s", strings.Join(allowed, ", "))
+}
+
+// attachmentDisposition builds a Content-Disposition download header, quoting
+// or RFC 2231-encoding filename as needed since it can contain usernames
+func attachmentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// startExportTable sets the download headers and writes the header row,
+// preceded by the ECSV metadata block when format is "ecsv". The caller
+// writes the data rows and flushes the returned writer.
+func startExportTable(c *gin.Context, format, basename string, columns []exportColumn) *csv.Writer {
+	extension := ".csv"
+	if format == "ecsv" {
+		extension = ".ecsv"
+	}
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", attachmentDisposition(basename+extension))
+
+	if format == "ecsv" {
+		writeECSVHeader(c.Writer, columns)
+	}
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(exportColumnNames(columns))
+	return writer
+}
+
+// writeECSVHeader writes the commented YAML block of an ECSV 1.0 file
+// with a comma delimiter, as read by astropy.table.Table.read
+func writeECSVHeader(w io.Writer, columns []exportColumn) {
+	io.WriteString(w, "# %ECSV 1.0\n")
+	fmt.Fprintln(w, "# ---")
+	fmt.Fprintln(w, "# delimiter: ','")
+	fmt.Fprintln(w, "# datatype:")
+	for _, col := range columns {
+		fields := []string{"name: " + col.name}
+		if col.unit != "" {
+			fields = append(fields, "unit: "+col.unit)
+		}
+		fields = append(fields, "datatype: "+col.datatype)
+		if col.description != "" {
+			fields = append(fields, "description: '"+strings.ReplaceAll(col.description, "'", "''")+"'")
+		}
+		fmt.Fprintf(w, "# - {%s}\n", strings.Join(fields, ", "))
+	}
+	fmt.Fprintln(w, "# schema: astropy-2.0")
+}
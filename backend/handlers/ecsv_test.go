@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAttachmentDisposition(t *testing.T) {
+	for _, filename := range []string{
+		"report_alice.pdf",
+		"report_o'brien smith.pdf",
+		`report_a"; filename=evil.exe.pdf`,
+		"report_josé.pdf",
+	} {
+		header := attachmentDisposition(filename)
+		disposition, params, err := mime.ParseMediaType(header)
+		if err != nil {
+			t.Errorf("%q: header %q does not parse: %v", filename, header, err)
+			continue
+		}
+		if disposition != "attachment" || params["filename"] != filename || len(params) != 1 {
+			t.Errorf("%q: header %q parses as %q %v", filename, header, disposition, params)
+		}
+	}
+}
+
+func TestStartExportTableQuotesFilename(t *testing.T) {
+	for _, format := range []string{"csv", "ecsv"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+		writer := startExportTable(c, format, "classifications_o'brien; x=y", classificationExportColumns)
+		writer.Flush()
+
+		_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+		if err != nil {
+			t.Fatalf("%s: bad Content-Disposition %q: %v", format, w.Header().Get("Content-Disposition"), err)
+		}
+		if want := "classifications_o'brien; x=y." + format; params["filename"] != want {
+			t.Errorf("%s: filename = %q, want %q", format, params["filename"], want)
+		}
+	}
+}
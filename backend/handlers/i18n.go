@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Languages error messages are translated to; the first is the default
+var messageLanguages = []language.Tag{language.English, language.Spanish}
+
+var languageMatcher = language.NewMatcher(messageLanguages)
+
+// Common error messages by key, then by base language
+var errorMessages = map[string]map[string]string{
+	"invalid_request_body": {
+		"en": "Invalid request body",
+		"es": "Cuerpo de la petición no válido",
+	},
+	"invalid_credentials": {
+		"en": "Invalid credentials",
+		"es": "Credenciales no válidas",
+	},
+	"invalid_limit": {
+		"en": "Invalid limit",
+		"es": "Límite no válido",
+	},
+	"invalid_curve_id": {
+		"en": "Invalid curve ID",
+		"es": "ID de curva no válido",
+	},
+	"curve_not_found": {
+		"en": "Curve not found",
+		"es": "Curva no encontrada",
+	},
+	"failed_find_curve": {
+		"en": "Failed to find curve",
+		"es": "No se pudo buscar la curva",
+	},
+	"invalid_transit_index": {
+		"en": "Invalid transit index",
+		"es": "Índice de tránsito no válido",
+	},
+	"transit_not_found": {
+		"en": "Transit not found",
+		"es": "Tránsito no encontrado",
+	},
+	"failed_find_transit": {
+		"en": "Failed to find transit",
+		"es": "No se pudo buscar el tránsito",
+	},
+	"plot_not_found": {
+		"en": "Plot not found",
+		"es": "Gráfica no encontrada",
+	},
+	"classification_locked": {
+		"en": "Classification is locked",
+		"es": "La clasificación está bloqueada",
+	},
+	"classification_not_found": {
+		"en": "Classification not found",
+		"es": "Clasificación no encontrada",
+	},
+	"failed_get_classifications": {
+		"en": "Failed to get classifications",
+		"es": "No se pudieron obtener las clasificaciones",
+	},
+	"invalid_user_id": {
+		"en": "Invalid user ID",
+		"es": "ID de usuario no válido",
+	},
+	"user_not_found": {
+		"en": "User not found",
+		"es": "Usuario no encontrado",
+	},
+}
+
+// errMsg returns the error message for key in the language preferred by the
+// request's Accept-Language header, falling back to English
+func errMsg(c *gin.Context, key string) string {
+	messages, ok := errorMessages[key]
+	if !ok {
+		return key
+	}
+
+	tags, _, _ := language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	_, index, _ := languageMatcher.Match(tags...)
+	base, _ := messageLanguages[index].Base()
+	if msg, ok := messages[base.String()]; ok {
+		return msg
+	}
+	return messages["en"]
+}
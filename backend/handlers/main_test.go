@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"emoons-web/db"
+	"emoons-web/db/dbtest"
+	"emoons-web/middleware"
+	"emoons-web/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// setupTestDB gives the test a fresh database and an empty curve cache
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dbtest.Setup(t)
+	models.InvalidateCurveCache()
+}
+
+// createTestUser adds a user and returns it with a bearer token for it
+func createTestUser(t *testing.T, username, role string) (*models.User, string) {
+	t.Helper()
+	user, err := models.CreateUser(username, "password", username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := middleware.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// serve sends a request with an optional JSON body through r as token's user
+func serve(r http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func countUserClassifications(t *testing.T, userID int64) int {
+	t.Helper()
+	var n int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM Classifications WHERE user_id = ?`, userID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
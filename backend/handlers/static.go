@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"emoons-web/middleware"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pre-compressed siblings in order of preference
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// PrecompressedStatic serves files from dir, preferring a .br or .gz sibling
+// when the client accepts that encoding. Mount it on a "/*filepath" route.
+func PrecompressedStatic(dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+c.Param("filepath"))))
+		c.Header("Vary", "Accept-Encoding")
+
+		// c.File would list a directory's contents
+		if info, err := os.Stat(name); err == nil && info.IsDir() {
+			http.NotFound(c.Writer, c.Request)
+			return
+		}
+
+		accepted := acceptedEncodings(c.GetHeader("Accept-Encoding"))
+		for _, pc := range precompressedEncodings {
+			if !accepted[pc.encoding] {
+				continue
+			}
+			if info, err := os.Stat(name + pc.extension); err != nil || info.IsDir() {
+				continue
+			}
+			if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+				c.Header("Content-Type", contentType)
+			}
+			c.Header("Content-Encoding", pc.encoding)
+			c.File(name + pc.extension)
+			return
+		}
+
+		c.File(name)
+	}
+}
+
+// ServePlot serves a plot image from dir through http.ServeContent, so Range
+// and conditional requests are honoured. Mount it on a "/:file" route.
+func ServePlot(dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("file")
+		if !validPlotName(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plot file"})
+			return
+		}
+
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "plot_not_found")})
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "plot_not_found")})
+			return
+		}
+
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+	}
+}
+
+// GetPlotURL returns a signed URL for a plot that stays valid for ttl
+func GetPlotURL(dir string, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("file")
+		if !validPlotName(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plot file"})
+			return
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "plot_not_found")})
+			return
+		}
+
+		expires := time.Now().Add(ttl).Truncate(time.Second)
+		c.JSON(http.StatusOK, gin.H{
+			"url":        middleware.SignPlotURL(name, expires),
+			"expires_at": expires.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// Plot names are bare file names inside the plots directory
+func validPlotName(name string) bool {
+	return name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
+
+func acceptedEncodings(header string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q := strings.TrimSpace(params); q == "q=0" || q == "q=0.0" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(encoding))] = true
+	}
+	return accepted
+}
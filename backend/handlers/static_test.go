@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServePlotRange(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("\x89PNG\r\n\x1a\n0123456789abcdef")
+	if err := os.WriteFile(filepath.Join(dir, "curveA_1.png"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET("/api/plots/:file", ServePlot(dir))
+
+	tests := []struct {
+		name, file, rangeHeader string
+		status                  int
+		body                    []byte
+		contentRange            string
+	}{
+		{"whole file", "curveA_1.png", "", http.StatusOK, content, ""},
+		{"range", "curveA_1.png", "bytes=8-11", http.StatusPartialContent, content[8:12], "bytes 8-11/24"},
+		{"suffix range", "curveA_1.png", "bytes=-4", http.StatusPartialContent, content[20:], "bytes 20-23/24"},
+		{"unsatisfiable", "curveA_1.png", "bytes=100-200", http.StatusRequestedRangeNotSatisfiable, nil, "bytes */24"},
+		{"missing", "curveA_9.png", "bytes=0-3", http.StatusNotFound, nil, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/plots/"+tt.file, nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: Content-Range %q, want %q", tt.name, got, tt.contentRange)
+		}
+		if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.Bytes(), tt.body)
+		}
+	}
+}
+
+func TestPrecompressedStatic(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.js":        "plain",
+		"app.js.br":     "brotli",
+		"app.js.gz":     "gzip",
+		"sub/style.css": "css",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/assets/*filepath", PrecompressedStatic(dir))
+
+	tests := []struct {
+		name, path, acceptEncoding string
+		status                     int
+		body, contentEncoding      string
+	}{
+		{"brotli preferred", "/assets/app.js", "gzip, br", http.StatusOK, "brotli", "br"},
+		{"gzip", "/assets/app.js", "gzip", http.StatusOK, "gzip", "gzip"},
+		{"refused encoding", "/assets/app.js", "br;q=0", http.StatusOK, "plain", ""},
+		{"no sibling", "/assets/sub/style.css", "br", http.StatusOK, "css", ""},
+		{"root directory", "/assets/", "", http.StatusNotFound, "", ""},
+		{"subdirectory", "/assets/sub", "", http.StatusNotFound, "", ""},
+		{"subdirectory with slash", "/assets/sub/", "br", http.StatusNotFound, "", ""},
+		{"missing", "/assets/missing.js", "", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.contentEncoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, got, tt.contentEncoding)
+		}
+		if tt.status == http.StatusOK && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body, tt.body)
+		}
+		if tt.status == http.StatusNotFound && bytes.Contains(w.Body.Bytes(), []byte("style.css")) {
+			t.Errorf("%s: response lists the directory: %q", tt.name, w.Body)
+		}
+	}
+}
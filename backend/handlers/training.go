@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"emoons-web/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrainingTransit struct {
+	ExampleID int64           `json:"example_id"`
+	Transit   *models.Transit `json:"transit"`
+}
+
+type TrainingAnswerRequest struct {
+	ExampleID int64 `json:"example_id" binding:"required"`
+	models.ClassificationInput
+}
+
+// GetNextTrainingExample serves a random training transit without its answer
+func GetNextTrainingExample(c *gin.Context) {
+	example, err := models.GetRandomTrainingExample()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get training example"})
+		return
+	}
+	if example == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	transit := models.GetTransit(example.Filename, example.TransitIndex)
+	if transit == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
+		return
+	}
+
+	c.JSON(http.StatusOK, TrainingTransit{ExampleID: example.ID, Transit: transit})
+}
+
+// AnswerTrainingExample scores the submitted flags against the expected ones;
+// nothing is stored
+func AnswerTrainingExample(c *gin.Context) {
+	var req TrainingAnswerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_request_body")})
+		return
+	}
+
+	example, err := models.GetTrainingExample(req.ExampleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get training example"})
+		return
+	}
+	if example == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Training example not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, example.Score(req.FlagValues()))
+}
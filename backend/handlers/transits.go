@@ -8,33 +8,195 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// parseTransitIndex reads the 1-indexed :index parameter and checks that
+// curve has a transit with that index. found_transits is only a count, so
+// the Transits table itself is the authority when indices have gaps.
+func parseTransitIndex(c *gin.Context, curve *models.Curve) (int, bool) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_transit_index")})
+		return 0, false
+	}
+
+	exists, err := models.TransitExists(curve.ID, index)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_transit")})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
+		return 0, false
+	}
+	return index, true
+}
+
 func GetTransit(c *gin.Context) {
 	filename := c.Param("file")
-	indexStr := c.Param("index")
 
-	index, err := strconv.Atoi(indexStr)
+	curve, err := models.GetCurveByFilename(filename)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transit index"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
 		return
 	}
 
 	transit := models.GetTransit(filename, index)
 	if transit == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transit not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
+		return
+	}
+
+	c.JSON(http.StatusOK, transit)
+}
+
+func GetTransitByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transit ID"})
+		return
+	}
+
+	transit, err := models.GetTransitByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transit"})
+		return
+	}
+	if transit == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
 		return
 	}
 
 	c.JSON(http.StatusOK, transit)
 }
 
+func GetTransitModelParams(c *gin.Context) {
+	filename := c.Param("file")
+
+	curve, err := models.GetCurveByFilename(filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	params, err := models.GetTransitModelParams(filename, index)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get model parameters"})
+		return
+	}
+	if params == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
+		return
+	}
+
+	c.JSON(http.StatusOK, params)
+}
+
+// GetTimeNeighbors returns the transits just before and after this one by
+// expected mid-transit time, which can differ from index order
+func GetTimeNeighbors(c *gin.Context) {
+	filename := c.Param("file")
+
+	curve, err := models.GetCurveByFilename(filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg(c, "failed_find_curve")})
+		return
+	}
+	if curve == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "curve_not_found")})
+		return
+	}
+
+	index, ok := parseTransitIndex(c, curve)
+	if !ok {
+		return
+	}
+
+	transit := models.GetTransit(filename, index)
+	if transit == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errMsg(c, "transit_not_found")})
+		return
+	}
+
+	prev, next, err := models.GetTimeNeighbors(curve.ID, transit.T0Expected, transit.TransitIndex)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get neighboring transits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"previous": prev, "next": next})
+}
+
+// parseFittedFilter reads the optional ?fitted=true|false parameter
+func parseFittedFilter(c *gin.Context) (*bool, bool) {
+	v := c.Query("fitted")
+	if v == "" {
+		return nil, true
+	}
+	fitted, err := strconv.ParseBool(v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fitted"})
+		return nil, false
+	}
+	return &fitted, true
+}
+
 func GetTransitsByFile(c *gin.Context) {
 	filename := c.Param("file")
 
-	transits := models.GetTransitsForFile(filename)
+	fitted, ok := parseFittedFilter(c)
+	if !ok {
+		return
+	}
+
+	transits := models.GetTransitsForFile(filename, fitted)
 	if transits == nil {
+		// The filter may exclude every transit of an existing file
+		if fitted != nil && models.GetTransitCount(filename) > 0 {
+			c.JSON(http.StatusOK, []models.Transit{})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "No transits found for file"})
 		return
 	}
 
 	c.JSON(http.StatusOK, transits)
 }
+
+func GetCoverageGaps(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg(c, "invalid_limit")})
+		return
+	}
+
+	minRaters, err := strconv.Atoi(c.DefaultQuery("min_raters", "1"))
+	if err != nil || minRaters < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_raters"})
+		return
+	}
+
+	transits, err := models.GetUnderCoveredTransits(minRaters, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get coverage gaps"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transits)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"emoons-web/db/dbtest"
+	"emoons-web/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseTransitIndex(t *testing.T) {
+	setupTestDB(t)
+
+	// Transit 2 is missing, so found_transits (2) undercounts the highest index (3)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename, found_transits) VALUES (1, 'curveA', 2)`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a_1.png'), (1, 3, 'a_3.png')`)
+	curve := &models.Curve{ID: 1, Filename: "curveA", FoundTransits: 2}
+
+	tests := []struct {
+		index  string
+		want   int
+		status int
+	}{
+		{"1", 1, http.StatusOK},
+		{"3", 3, http.StatusOK},
+		{"0", 0, http.StatusBadRequest},
+		{"-5", 0, http.StatusBadRequest},
+		{"abc", 0, http.StatusBadRequest},
+		{"2", 0, http.StatusNotFound},
+		{"4", 0, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Params = gin.Params{{Key: "index", Value: tt.index}}
+
+		got, ok := parseTransitIndex(c, curve)
+		if ok != (tt.status == http.StatusOK) || got != tt.want {
+			t.Errorf("index %q: got (%d, %v), want %d", tt.index, got, ok, tt.want)
+		}
+		if !ok && w.Code != tt.status {
+			t.Errorf("index %q: status %d, want %d", tt.index, w.Code, tt.status)
+		}
+	}
+}
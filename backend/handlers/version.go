@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+func GetVersion(info VersionInfo) gin.HandlerFunc {
+	info.GoVersion = runtime.Version()
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, info)
+	}
+}
@@ -8,12 +8,25 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+	// Embedded zoneinfo for APP_TIMEZONE, the Alpine runtime image ships none
+	_ "time/tzdata"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+// Set at build time, e.g.
+// go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -21,15 +34,132 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		log.Fatalf("Failed to resolve path %s: %v", path, err)
+	}
+	return abs
+}
+
 func main() {
 	// Configuration
-	dbPath := getEnv("DATABASE_PATH", "../db/transit_analysis.db")
-	csvPath := getEnv("TRANSITS_CSV_PATH", "../plots/transits.csv")
-	plotsDir := getEnv("PLOTS_DIR", "../plots")
+	dataDir := getEnv("DATA_DIR", "..")
+	dbPath := resolvePath(getEnv("DATABASE_PATH", filepath.Join(dataDir, "db", "transit_analysis.db")))
+	replicaPath := getEnv("DATABASE_REPLICA_PATH", "")
+	csvPath := resolvePath(getEnv("TRANSITS_CSV_PATH", filepath.Join(dataDir, "plots", "transits.csv")))
+	curvesCsvPath := resolvePath(getEnv("CURVES_CSV_PATH", filepath.Join(dataDir, "plots", "curves.csv")))
+	plotsDir := resolvePath(getEnv("PLOTS_DIR", filepath.Join(dataDir, "plots")))
 	frontendDir := getEnv("FRONTEND_DIR", "")
 	port := getEnv("PORT", "8080")
 	adminUsername := getEnv("ADMIN_USERNAME", "admin")
 	adminPassword := getEnv("ADMIN_PASSWORD", "admin")
+	guestAccess := getEnv("GUEST_ACCESS", "false") == "true"
+	middleware.GuestAccess = guestAccess
+	models.RequireNotesForAnomaly = getEnv("REQUIRE_NOTES_FOR_ANOMALY", "false") == "true"
+	appTimezone := getEnv("APP_TIMEZONE", "UTC")
+	csvDelimiter := getEnv("CSV_DELIMITER", ",")
+	csvEncoding := getEnv("CSV_ENCODING", "utf-8")
+	// Falls back to the resolved JWT secret (never empty) so anonymized IDs
+	// are not guessable from user IDs
+	anonymizeKey := []byte(os.Getenv("EXPORT_ANONYMIZE_KEY"))
+	if len(anonymizeKey) == 0 {
+		anonymizeKey = middleware.JWTSecret()
+	}
+	backupDir := getEnv("BACKUP_DIR", "")
+	backupInterval := getEnv("BACKUP_INTERVAL", "24h")
+	backupKeep := getEnv("BACKUP_KEEP", "7")
+	minRatersSetting := getEnv("MIN_RATERS", "3")
+	privateCacheMaxAge := getEnv("CACHE_PRIVATE_MAX_AGE", "30s")
+	publicCacheMaxAge := getEnv("CACHE_PUBLIC_MAX_AGE", "5m")
+	queryTimeout := getEnv("DB_QUERY_TIMEOUT", "60s")
+	insertBatchSize := getEnv("TRANSIT_INSERT_BATCH_SIZE", "100")
+	plotURLTTL := getEnv("PLOT_URL_TTL", "15m")
+	heavyConcurrency := getEnv("HEAVY_QUERY_CONCURRENCY", "2")
+	statsRefresh := getEnv("USER_STATS_REFRESH_INTERVAL", "5m")
+
+	csvFormat, err := models.ParseCSVFormat(csvDelimiter, csvEncoding)
+	if err != nil {
+		log.Fatalf("Invalid CSV format: %v", err)
+	}
+	models.CSVImportFormat = csvFormat
+
+	models.TransitInsertBatchSize, err = strconv.Atoi(insertBatchSize)
+	if err != nil || models.TransitInsertBatchSize < 1 {
+		log.Fatalf("Invalid TRANSIT_INSERT_BATCH_SIZE %q", insertBatchSize)
+	}
+
+	appLocation, err := time.LoadLocation(appTimezone)
+	if err != nil {
+		log.Fatalf("Invalid APP_TIMEZONE %q: %v", appTimezone, err)
+	}
+
+	minRaters, err := strconv.Atoi(minRatersSetting)
+	if err != nil || minRaters < 1 {
+		log.Fatalf("Invalid MIN_RATERS %q", minRatersSetting)
+	}
+
+	if backupDir != "" {
+		backupDir = resolvePath(backupDir)
+	}
+	backupEvery, err := time.ParseDuration(backupInterval)
+	if err != nil || backupEvery <= 0 {
+		log.Fatalf("Invalid BACKUP_INTERVAL %q", backupInterval)
+	}
+	keepBackups, err := strconv.Atoi(backupKeep)
+	if err != nil || keepBackups < 0 {
+		log.Fatalf("Invalid BACKUP_KEEP %q", backupKeep)
+	}
+
+	db.QueryTimeout, err = time.ParseDuration(queryTimeout)
+	if err != nil || db.QueryTimeout < 0 {
+		log.Fatalf("Invalid DB_QUERY_TIMEOUT %q", queryTimeout)
+	}
+
+	heavyLimit, err := strconv.Atoi(heavyConcurrency)
+	if err != nil || heavyLimit < 0 {
+		log.Fatalf("Invalid HEAVY_QUERY_CONCURRENCY %q", heavyConcurrency)
+	}
+
+	statsRefreshEvery, err := time.ParseDuration(statsRefresh)
+	if err != nil || statsRefreshEvery < 0 {
+		log.Fatalf("Invalid USER_STATS_REFRESH_INTERVAL %q", statsRefresh)
+	}
+
+	plotURLLifetime, err := time.ParseDuration(plotURLTTL)
+	if err != nil || plotURLLifetime <= 0 {
+		log.Fatalf("Invalid PLOT_URL_TTL %q", plotURLTTL)
+	}
+
+	privateCacheTTL, err := time.ParseDuration(privateCacheMaxAge)
+	if err != nil {
+		log.Fatalf("Invalid CACHE_PRIVATE_MAX_AGE %q: %v", privateCacheMaxAge, err)
+	}
+	publicCacheTTL, err := time.ParseDuration(publicCacheMaxAge)
+	if err != nil {
+		log.Fatalf("Invalid CACHE_PUBLIC_MAX_AGE %q: %v", publicCacheMaxAge, err)
+	}
+	// Per-user reads vs. data that is the same for everyone until the next import
+	privateCache := middleware.CacheControl("private", privateCacheTTL)
+	publicCache := middleware.CacheControl("public", publicCacheTTL)
+	// Shared by the exports and all-users stats, which scan whole tables
+	heavy := middleware.ConcurrencyLimit(heavyLimit, 10*time.Second)
+
+	log.Printf("Version %s (commit %s, built %s)", version, commit, buildTime)
+	log.Printf("Database: %s", dbPath)
+	log.Printf("Transits CSV: %s", csvPath)
+	log.Printf("Curves CSV: %s", curvesCsvPath)
+	log.Printf("Plots directory: %s", plotsDir)
+
+	// Serving a missing plots directory would only show up as 404s later
+	if info, err := os.Stat(plotsDir); err != nil || !info.IsDir() {
+		log.Fatalf("Plots directory %s does not exist or is not a directory", plotsDir)
+	}
+
+	// SQLite creates the file but not its parent directory
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+		log.Fatalf("Failed to create database directory: %v", err)
+	}
 
 	// Connect to database
 	if err := db.Connect(dbPath); err != nil {
@@ -37,18 +167,41 @@ func main() {
 	}
 	defer db.Close()
 
+	// Optional read replica for listings, stats and exports
+	if replicaPath != "" {
+		if err := db.ConnectReplica(resolvePath(replicaPath)); err != nil {
+			log.Fatalf("Failed to connect to read replica: %v", err)
+		}
+	}
+
 	// Run migrations
 	if err := db.RunMigrations(); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Fail fast if a migration left the schema in an unexpected state
+	if err := db.VerifySchema(); err != nil {
+		log.Fatalf("Failed to verify database schema: %v", err)
+	}
+
+	if statsRefreshEvery > 0 {
+		models.StartUserStatsRefresh(statsRefreshEvery)
+	}
+
+	// Every login adds a session row, so sweep out the unusable ones
+	middleware.StartSessionPruning(time.Hour)
+
+	if backupDir != "" {
+		db.StartBackups(backupDir, backupEvery, keepBackups)
+		log.Printf("Backing up database to %s every %s (keeping %d)", backupDir, backupEvery, keepBackups)
+	}
+
 	// Ensure admin user exists
 	if err := models.EnsureAdminUser(adminUsername, adminPassword); err != nil {
 		log.Fatalf("Failed to ensure admin user: %v", err)
 	}
 
 	// Load curves from CSV
-	curvesCsvPath := getEnv("CURVES_CSV_PATH", "../plots/curves.csv")
 	if err := models.LoadCurvesFromCSV(curvesCsvPath); err != nil {
 		log.Printf("Warning: Failed to load curves CSV: %v", err)
 	}
@@ -72,15 +225,22 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	// Serve static plot images
-	r.Static("/plots", plotsDir)
-
 	// Public routes
+	r.GET("/api/version", handlers.GetVersion(handlers.VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+	}))
 	r.POST("/api/auth/login", handlers.Login)
+	// Signed URLs let <img> tags load plots without the Authorization header
+	r.GET("/api/plots/:file", middleware.PlotAccess(), publicCache, handlers.ServePlot(plotsDir))
+	if guestAccess {
+		r.POST("/api/auth/guest", handlers.GuestLogin)
+	}
 
 	// Protected routes
 	api := r.Group("/api")
-	api.Use(middleware.AuthRequired())
+	api.Use(middleware.AuthRequired(), middleware.GuestReadOnly())
 	{
 		// Auth
 		api.GET("/auth/me", handlers.GetMe)
@@ -88,37 +248,125 @@ func main() {
 
 		// Curves
 		api.GET("/curves", handlers.GetCurves)
-		api.GET("/curves/:id", handlers.GetCurve)
-		api.GET("/curves/:id/transits", handlers.GetCurveTransits)
+		api.POST("/curves/claim", handlers.ClaimNextCurve)
+		api.GET("/curves/priority", privateCache, handlers.GetPriorityCurves(minRaters))
+		api.GET("/curves/:id", privateCache, handlers.GetCurve)
+		api.POST("/curves/:id/verdict", handlers.SaveCurveVerdict)
+		api.GET("/curves/:id/transits", publicCache, handlers.GetCurveTransits)
+		api.GET("/curves/:id/ttv-outliers", publicCache, handlers.GetTTVOutliers)
+		api.GET("/curves/:id/duration-anomalies", publicCache, handlers.GetDurationAnomalies)
+		api.GET("/curves/:id/notes", privateCache, handlers.GetCurveNotes)
+		api.GET("/curves/:id/consensus", middleware.RoleRequired(models.RoleReviewer), privateCache, handlers.GetCurveConsensus)
+		api.GET("/curves/:id/rater-counts", middleware.RoleRequired(models.RoleReviewer), handlers.GetCurveRaterCounts)
+		api.POST("/curves/:id/complete", handlers.CompleteCurve)
+		api.DELETE("/curves/:id/complete", handlers.UncompleteCurve)
+
+		// Plots
+		api.GET("/plots/:file/url", handlers.GetPlotURL(plotsDir, plotURLLifetime))
 
 		// Transits
-		api.GET("/transits/:file", handlers.GetTransitsByFile)
-		api.GET("/transits/:file/:index", handlers.GetTransit)
+		api.GET("/transits/:file", publicCache, handlers.GetTransitsByFile)
+		api.GET("/transits/id/:id", publicCache, handlers.GetTransitByID)
+		api.GET("/transits/:file/:index", publicCache, handlers.GetTransit)
+		api.GET("/transits/:file/:index/model-params", publicCache, handlers.GetTransitModelParams)
+		api.GET("/transits/:file/:index/time-neighbors", publicCache, handlers.GetTimeNeighbors)
 
 		// Classifications
 		api.GET("/transits/:file/:index/classify", handlers.GetClassification)
 		api.POST("/transits/:file/:index/classify", handlers.SaveClassification)
+		api.DELETE("/transits/:file/:index/classify", handlers.DeleteClassification)
+		api.POST("/transits/:file/:index/flag", handlers.FlagTransitForReview)
+		api.GET("/transits/:file/:index/final", handlers.GetFinalClassification)
+		api.GET("/curves/:id/classifications", handlers.GetCurveClassificationsForIndices)
 		api.DELETE("/curves/:id/classifications", handlers.DeleteCurveClassifications)
+		api.GET("/classifications", handlers.GetTaggedClassifications)
+		api.GET("/classifications/search", privateCache, handlers.SearchClassifications)
+		api.GET("/classifications/vs-consensus", privateCache, handlers.GetVsConsensus)
+
+		// Training
+		api.GET("/training/next", handlers.GetNextTrainingExample)
+		api.POST("/training/answer", handlers.AnswerTrainingExample)
 
 		// Stats
-		api.GET("/stats", handlers.GetStats)
+		api.GET("/stats", privateCache, handlers.GetStats)
+		api.GET("/stats/by-datatype", privateCache, handlers.GetStatsByDataType)
+		api.GET("/stats/co-occurrence", privateCache, handlers.GetFlagCoOccurrence)
+		api.GET("/stats/flag-rates", privateCache, handlers.GetFlagRates)
+		api.GET("/stats/calibration", privateCache, handlers.GetCalibration)
+		api.GET("/stats/consensus-agreement", privateCache, handlers.GetConsensusAgreement)
+		api.GET("/stats/coverage-gaps", privateCache, handlers.GetCoverageGaps)
+		api.GET("/stats/streak", privateCache, handlers.GetStreak(appLocation))
+		api.GET("/stats/project-progress", privateCache, handlers.GetProjectProgress)
+		api.GET("/stats/raters-remaining", privateCache, handlers.GetRatersRemaining(minRaters))
+
+		// Reviewer routes
+		review := api.Group("/review")
+		review.Use(middleware.RoleRequired(models.RoleReviewer))
+		{
+			review.POST("/transits/:file/:index/lock", handlers.LockTransit)
+			review.DELETE("/transits/:file/:index/lock", handlers.UnlockTransit)
+		}
+
+		// Adjudication is open to reviewers despite living under /admin
+		api.PUT("/admin/transits/:file/:index/final", middleware.RoleRequired(models.RoleReviewer), middleware.AuditLog(), handlers.SetFinalClassification)
 
 		// Admin routes
 		admin := api.Group("/admin")
-		admin.Use(middleware.AdminRequired())
+		admin.Use(middleware.AdminRequired(), middleware.AuditLog())
 		{
 			admin.GET("/users", handlers.ListUsers)
+			admin.GET("/audit", handlers.GetAuditLog)
 			admin.POST("/users", handlers.CreateUser)
 			admin.PUT("/users/:id", handlers.UpdateUser)
 			admin.DELETE("/users/:id", handlers.DeleteUser)
+			admin.POST("/users/:id/deactivate", handlers.DeactivateUser)
+			admin.POST("/users/:id/activate", handlers.ActivateUser)
 			admin.GET("/users/:id/stats", handlers.GetUserStats)
-			admin.GET("/users/:id/export", handlers.ExportUserClassifications)
+			admin.GET("/users/:id/progress", handlers.GetUserProgress)
+			admin.GET("/users/:id/throughput", handlers.GetUserThroughput)
+			admin.GET("/users/:id/metrics", handlers.GetUserFlagMetrics)
+			admin.GET("/users/:id/export", heavy, handlers.ExportUserClassifications)
+			admin.GET("/users/:id/export/count", handlers.CountUserClassificationsForExport)
+			admin.POST("/users/:id/import-classifications", handlers.ImportUserClassifications)
+			admin.GET("/export", heavy, handlers.ExportAllClassifications(anonymizeKey))
+			admin.GET("/export/count", handlers.CountAllClassificationsForExport)
+			admin.GET("/export/disagreements", heavy, handlers.ExportDisagreements)
+			admin.GET("/transits/:file/:index/history/export", handlers.ExportTransitHistory)
+			admin.GET("/users/:id/report.pdf", heavy, handlers.GetUserReportPDF)
+			admin.GET("/users/:id/plots.zip", heavy, handlers.ExportUserPlots(plotsDir))
+			admin.DELETE("/users/:id/curves/:curveId/classifications", handlers.DeleteUserCurveClassifications)
+			admin.DELETE("/users/:id/transits/:file/:index/classify", handlers.DeleteUserClassification)
+			admin.GET("/stats/by-datatype", heavy, handlers.GetAdminStatsByDataType)
+			admin.GET("/transit-discrepancies", handlers.GetTransitDiscrepancies)
+			admin.GET("/plot-manifest", handlers.GetPlotManifest)
+			admin.GET("/heatmap", heavy, handlers.GetCurveFlagHeatmap)
+			admin.GET("/empty-curves", handlers.GetEmptyCurves)
+			admin.GET("/contentious", heavy, handlers.GetContentiousTransits)
+			admin.GET("/notes/search", handlers.SearchNotes)
+			admin.GET("/gold-agreement", heavy, handlers.GetGoldAgreement)
+			admin.GET("/confusion", heavy, handlers.GetConfusionMatrix)
+			admin.POST("/assign", handlers.BulkAssignCurves)
+			admin.PUT("/curves/:id/notes", handlers.SetCurveNotes)
+			admin.GET("/curves/:id/raters", handlers.GetCurveRaters)
+			admin.GET("/training", handlers.ListTrainingExamples)
+			admin.POST("/training", handlers.SaveTrainingExample)
+			admin.DELETE("/training/:id", handlers.DeleteTrainingExample)
+			admin.GET("/events", handlers.StreamEvents)
+			admin.POST("/import", handlers.ReloadData(curvesCsvPath, csvPath))
+			admin.POST("/backup", handlers.TriggerBackup(backupDir, keepBackups))
+			admin.GET("/classifications", handlers.ListClassificationsAfter)
+			admin.GET("/classifications/changed", handlers.GetClassificationsChangedSince)
+			admin.GET("/empty-classifications", handlers.GetEmptyClassifications)
+			admin.GET("/flagged", handlers.GetReviewFlags)
+			admin.GET("/orphans", handlers.GetOrphanClassifications)
+			admin.POST("/orphans/purge", handlers.PurgeOrphanClassifications)
 		}
 	}
 
 	// Serve frontend static files (for production)
 	if frontendDir != "" {
-		r.Static("/assets", frontendDir+"/assets")
+		r.GET("/assets/*filepath", handlers.PrecompressedStatic(frontendDir+"/assets"))
+		r.HEAD("/assets/*filepath", handlers.PrecompressedStatic(frontendDir+"/assets"))
 		r.StaticFile("/favicon.ico", frontendDir+"/favicon.ico")
 		r.StaticFile("/logo.jpg", frontendDir+"/logo.jpg")
 		r.StaticFile("/login-bg.png", frontendDir+"/login-bg.png")
@@ -126,7 +374,7 @@ func main() {
 		// SPA fallback: serve index.html for non-API, non-static routes
 		r.NoRoute(func(c *gin.Context) {
 			path := c.Request.URL.Path
-			if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/plots/") {
+			if !strings.HasPrefix(path, "/api/") {
 				c.File(frontendDir + "/index.html")
 				return
 			}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"emoons-web/models"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditLog records every non-GET request handled by the group in the audit
+// log, once the handler has run and the status is known
+func AuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			return
+		}
+		action := c.Request.Method + " " + c.FullPath()
+		if err := models.RecordAudit(GetUserID(c), action, c.Request.URL.Path, c.Writer.Status()); err != nil {
+			log.Printf("Failed to record audit entry %q: %v", action, err)
+		}
+	}
+}
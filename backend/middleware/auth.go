@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"emoons-web/models"
+	"errors"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -13,30 +15,114 @@ import (
 
 var jwtSecret []byte
 
+// Expected iss and aud claims, so tokens minted by other services sharing the secret are rejected
+var (
+	jwtIssuer   string
+	jwtAudience string
+)
+
+// Zero disables the idle timeout
+var sessionIdleTimeout time.Duration
+
+// When set, a new login revokes every other session of the same user
+var singleSession bool
+
+// GuestAccess mirrors GUEST_ACCESS. While it is off, guest tokens issued
+// before it was turned off are rejected.
+var GuestAccess bool
+
+// Minimum interval between last_seen updates for the same session
+const sessionTouchInterval = time.Minute
+
+// How long issued tokens, and so their sessions, stay valid
+const tokenLifetime = 24 * time.Hour
+
 func init() {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "dev-secret-change-in-production"
 	}
 	jwtSecret = []byte(secret)
+
+	plotURLKey = jwtSecret
+	if key := os.Getenv("PLOT_URL_SECRET"); key != "" {
+		plotURLKey = []byte(key)
+	}
+
+	jwtIssuer = os.Getenv("JWT_ISSUER")
+	if jwtIssuer == "" {
+		jwtIssuer = "emoons-web"
+	}
+	jwtAudience = os.Getenv("JWT_AUDIENCE")
+	if jwtAudience == "" {
+		jwtAudience = "emoons-web"
+	}
+
+	if v := os.Getenv("SESSION_IDLE_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid SESSION_IDLE_TIMEOUT %q: %v", v, err)
+		}
+		sessionIdleTimeout = timeout
+	}
+
+	singleSession = os.Getenv("SINGLE_SESSION") == "true"
+}
+
+// JWTSecret returns the resolved token signing secret, for other keyed
+// hashes that have no secret of their own configured
+func JWTSecret() []byte {
+	return jwtSecret
 }
 
 type Claims struct {
 	UserID   int64  `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
 	IsAdmin  bool   `json:"is_admin"`
+	IsGuest  bool   `json:"is_guest,omitempty"`
 	jwt.RegisteredClaims
 }
 
+func newRegisteredClaims(id string) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		ID:        id,
+		Issuer:    jwtIssuer,
+		Audience:  jwt.ClaimStrings{jwtAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenLifetime)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+}
+
 func GenerateToken(user *models.User) (string, error) {
+	sessionID, err := models.CreateSession(user.ID)
+	if err != nil {
+		return "", err
+	}
+	if singleSession {
+		if err := models.DeleteOtherSessions(user.ID, sessionID); err != nil {
+			return "", err
+		}
+	}
+
+	claims := Claims{
+		UserID:           user.ID,
+		Username:         user.Username,
+		Role:             user.Role,
+		IsAdmin:          user.IsAdmin,
+		RegisteredClaims: newRegisteredClaims(sessionID),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// Guest tokens carry no user ID and no session
+func GenerateGuestToken() (string, error) {
 	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		IsAdmin:  user.IsAdmin,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+		Username:         "guest",
+		IsGuest:          true,
+		RegisteredClaims: newRegisteredClaims(""),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -47,14 +133,14 @@ func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required", "code": "token_missing"})
 			c.Abort()
 			return
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format", "code": "token_malformed"})
 			c.Abort()
 			return
 		}
@@ -64,21 +150,106 @@ func AuthRequired() gin.HandlerFunc {
 
 		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 			return jwtSecret, nil
-		})
+		}, jwt.WithIssuer(jwtIssuer), jwt.WithAudience(jwtAudience))
 
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			// Expired tokens can be refreshed, anything else needs a new login
+			switch {
+			case errors.Is(err, jwt.ErrTokenExpired):
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired", "code": "token_expired"})
+			case errors.Is(err, jwt.ErrTokenMalformed):
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Malformed token", "code": "token_malformed"})
+			default:
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "code": "token_invalid"})
+			}
+			c.Abort()
+			return
+		}
+
+		if claims.IsGuest && !GuestAccess {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Guest access is disabled", "code": "guest_disabled"})
 			c.Abort()
 			return
 		}
 
+		// Every user token has a session, and revoking it (logout, deactivation,
+		// single-session logins) must take effect before the token expires
+		if !claims.IsGuest && !checkSession(c, claims) {
+			return
+		}
+
 		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.ID)
 		c.Set("username", claims.Username)
-		c.Set("is_admin", claims.IsAdmin)
+		// Tokens issued before roles existed only carry is_admin
+		role := claims.Role
+		if role == "" && !claims.IsGuest {
+			role = models.RoleUser
+			if claims.IsAdmin {
+				role = models.RoleAdmin
+			}
+		}
+
+		c.Set("role", role)
+		c.Set("is_admin", role == models.RoleAdmin)
+		c.Set("is_guest", claims.IsGuest)
 		c.Next()
 	}
 }
 
+// PruneSessions deletes the sessions no token can use anymore: expired, or
+// idle past SESSION_IDLE_TIMEOUT. Revoked sessions are deleted when revoked.
+func PruneSessions() (int64, error) {
+	return models.DeleteExpiredSessions(tokenLifetime, sessionIdleTimeout)
+}
+
+// StartSessionPruning runs PruneSessions now and then every interval until
+// the process exits
+func StartSessionPruning(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if n, err := PruneSessions(); err != nil {
+				log.Printf("Session pruning failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d expired sessions", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func checkSession(c *gin.Context, claims *Claims) bool {
+	session, err := models.GetSession(claims.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+		c.Abort()
+		return false
+	}
+	if session == nil || session.UserID != claims.UserID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found", "code": "session_not_found"})
+		c.Abort()
+		return false
+	}
+
+	idle := time.Since(session.LastSeen)
+	if sessionIdleTimeout > 0 && idle > sessionIdleTimeout {
+		_ = models.DeleteSession(session.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired due to inactivity", "code": "session_expired"})
+		c.Abort()
+		return false
+	}
+
+	// Throttle writes: only refresh last_seen once per interval
+	if idle > sessionTouchInterval {
+		if err := models.TouchSession(session.ID); err != nil {
+			log.Printf("Failed to touch session %s: %v", session.ID, err)
+		}
+	}
+	return true
+}
+
 func AdminRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !GetIsAdmin(c) {
@@ -90,6 +261,32 @@ func AdminRequired() gin.HandlerFunc {
 	}
 }
 
+func RoleRequired(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasRole(c, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func GuestReadOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if GetIsGuest(c) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Guest access is read-only"})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
 func GetUserID(c *gin.Context) int64 {
 	if id, exists := c.Get("user_id"); exists {
 		return id.(int64)
@@ -97,9 +294,35 @@ func GetUserID(c *gin.Context) int64 {
 	return 0
 }
 
+func GetSessionID(c *gin.Context) string {
+	if id, exists := c.Get("session_id"); exists {
+		return id.(string)
+	}
+	return ""
+}
+
+func GetRole(c *gin.Context) string {
+	if role, exists := c.Get("role"); exists {
+		return role.(string)
+	}
+	return ""
+}
+
+func HasRole(c *gin.Context, role string) bool {
+	current := GetRole(c)
+	return current != "" && models.RoleAtLeast(current, role)
+}
+
 func GetIsAdmin(c *gin.Context) bool {
 	if isAdmin, exists := c.Get("is_admin"); exists {
 		return isAdmin.(bool)
 	}
 	return false
 }
+
+func GetIsGuest(c *gin.Context) bool {
+	if isGuest, exists := c.Get("is_guest"); exists {
+		return isGuest.(bool)
+	}
+	return false
+}
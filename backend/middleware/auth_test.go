@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"emoons-web/db/dbtest"
+	"emoons-web/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func signClaims(t *testing.T, claims Claims, secret []byte) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthRequiredErrorCodes(t *testing.T) {
+	dbtest.Setup(t)
+	user, err := models.CreateUser("alice", "password", "Alice", models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID, err := models.CreateSession(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(modify func(*Claims)) Claims {
+		c := Claims{UserID: user.ID, Username: user.Username, Role: user.Role,
+			RegisteredClaims: newRegisteredClaims(sessionID)}
+		modify(&c)
+		return c
+	}
+	expired := signClaims(t, claims(func(c *Claims) {
+		c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	}), jwtSecret)
+	wrongSecret := signClaims(t, claims(func(*Claims) {}), []byte("some other secret"))
+	wrongIssuer := signClaims(t, claims(func(c *Claims) { c.Issuer = "another-service" }), jwtSecret)
+	revoked := signClaims(t, claims(func(*Claims) {}), jwtSecret)
+	guest, err := GenerateGuestToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.DeleteSession(sessionID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions are only looked up while the idle timeout is on
+	sessionIdleTimeout = time.Hour
+	t.Cleanup(func() { sessionIdleTimeout = 0 })
+
+	r := gin.New()
+	r.GET("/", AuthRequired(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		code   string
+	}{
+		{"valid", "Bearer " + valid, http.StatusOK, ""},
+		{"missing", "", http.StatusUnauthorized, "token_missing"},
+		{"not bearer", "Basic " + valid, http.StatusUnauthorized, "token_malformed"},
+		{"garbage", "Bearer not.a.jwt", http.StatusUnauthorized, "token_malformed"},
+		{"expired", "Bearer " + expired, http.StatusUnauthorized, "token_expired"},
+		{"wrong secret", "Bearer " + wrongSecret, http.StatusUnauthorized, "token_invalid"},
+		{"wrong issuer", "Bearer " + wrongIssuer, http.StatusUnauthorized, "token_invalid"},
+		{"revoked session", "Bearer " + revoked, http.StatusUnauthorized, "session_not_found"},
+		{"guest, access disabled", "Bearer " + guest, http.StatusUnauthorized, "guest_disabled"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.code == "" {
+			continue
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tt.code {
+			t.Errorf("%s: code %q (%v), want %q", tt.name, body.Code, err, tt.code)
+		}
+	}
+
+	GuestAccess = true
+	t.Cleanup(func() { GuestAccess = false })
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+guest)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("guest, access enabled: status %d, want 200", w.Code)
+	}
+}
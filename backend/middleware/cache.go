@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheControl sets "Cache-Control: <scope>, max-age=N" on successful GET and
+// HEAD responses. Use "private" for per-user data and "public" for data shared
+// by everyone. A zero maxAge disables the header.
+//
+// Private responses also get "Vary: Authorization": the user is picked by the
+// bearer token rather than the URL, so a cache must not hand one user's
+// response to another.
+func CacheControl(scope string, maxAge time.Duration) gin.HandlerFunc {
+	value := fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds()))
+	return func(c *gin.Context) {
+		if maxAge > 0 && (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) {
+			c.Writer = &cacheControlWriter{ResponseWriter: c.Writer, value: value, private: scope == "private"}
+		}
+		c.Next()
+	}
+}
+
+// Adds the header once the status is known, so errors are never cached
+type cacheControlWriter struct {
+	gin.ResponseWriter
+	value   string
+	private bool
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		w.Header().Set("Cache-Control", w.value)
+		if w.private {
+			w.Header().Add("Vary", "Authorization")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheControl(t *testing.T) {
+	r := gin.New()
+	r.GET("/private", CacheControl("private", 30*time.Second), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	r.GET("/public", CacheControl("public", 5*time.Minute), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	r.GET("/failing", CacheControl("private", 30*time.Second), func(c *gin.Context) {
+		c.JSON(http.StatusInternalServerError, gin.H{})
+	})
+	r.POST("/private", CacheControl("private", 30*time.Second), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	r.GET("/disabled", CacheControl("private", 0), func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+
+	tests := []struct {
+		method, path       string
+		cacheControl, vary string
+	}{
+		{http.MethodGet, "/private", "private, max-age=30", "Authorization"},
+		{http.MethodGet, "/public", "public, max-age=300", ""},
+		{http.MethodGet, "/failing", "", ""},
+		{http.MethodPost, "/private", "", ""},
+		{http.MethodGet, "/disabled", "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("%s %s: Cache-Control %q, want %q", tt.method, tt.path, got, tt.cacheControl)
+		}
+		if got := w.Header().Get("Vary"); got != tt.vary {
+			t.Errorf("%s %s: Vary %q, want %q", tt.method, tt.path, got, tt.vary)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit lets at most limit requests through at once across every
+// route it is attached to. Extra requests get 503 with Retry-After instead of
+// queueing up on the database. A limit below 1 disables it.
+func ConcurrencyLimit(limit int, retryAfter time.Duration) gin.HandlerFunc {
+	if limit < 1 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	slots := make(chan struct{}, limit)
+	retrySeconds := strconv.Itoa(max(1, int(retryAfter.Seconds())))
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			c.Header("Retry-After", retrySeconds)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later", "code": "busy"})
+			c.Abort()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Key for signed plot URLs, defaults to the JWT secret
+var plotURLKey []byte
+
+func plotSignature(file string, expires int64) string {
+	mac := hmac.New(sha256.New, plotURLKey)
+	mac.Write([]byte(file + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPlotURL returns a plot URL that can be fetched without an
+// Authorization header until expires, so it works as an <img> src
+func SignPlotURL(file string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", plotSignature(file, expires.Unix()))
+	return "/api/plots/" + url.PathEscape(file) + "?" + query.Encode()
+}
+
+// PlotAccess accepts a valid, unexpired plot signature in place of the JWT.
+// Requests without a signature go through AuthRequired as usual.
+func PlotAccess() gin.HandlerFunc {
+	auth := AuthRequired()
+	return func(c *gin.Context) {
+		sig, hasSig := c.GetQuery("sig")
+		if !hasSig {
+			auth(c)
+			return
+		}
+
+		expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+		if err != nil || time.Now().Unix() > expires ||
+			!hmac.Equal([]byte(sig), []byte(plotSignature(c.Param("file"), expires))) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired plot signature", "code": "signature_invalid"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
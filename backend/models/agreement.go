@@ -0,0 +1,258 @@
+package models
+
+import (
+	"emoons-web/db"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type UserComparison struct {
+	UserID     int64 `json:"user_id"`
+	OtherID    int64 `json:"other_id"`
+	SampleSize int   `json:"sample_size"`
+	// Percentages (0-100) of commonly classified transits where both users
+	// gave the same answer; nil when they share no transits
+	FlagAgreement map[string]*float64 `json:"flag_agreement"`
+	Overall       *float64            `json:"overall"`
+}
+
+// CompareUsers measures per-flag agreement between two users over the
+// transits both have classified
+func CompareUsers(userID, otherID int64) (*UserComparison, error) {
+	n := len(ClassificationFlags)
+	matches := make([]string, n)
+	for i, flag := range ClassificationFlags {
+		matches[i] = "COALESCE(SUM(CASE WHEN COALESCE(a." + flag + ", 0) = COALESCE(b." + flag + ", 0) THEN 1 ELSE 0 END), 0)"
+	}
+
+	counts := make([]int, n)
+	comparison := &UserComparison{
+		UserID:        userID,
+		OtherID:       otherID,
+		FlagAgreement: make(map[string]*float64, n),
+	}
+	dest := []any{&comparison.SampleSize}
+	for i := range counts {
+		dest = append(dest, &counts[i])
+	}
+
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*), `+strings.Join(matches, ", ")+`
+		FROM Classifications a
+		JOIN Classifications b
+			ON b.curve_id = a.curve_id AND b.transit_index = a.transit_index
+		WHERE a.user_id = ? AND b.user_id = ?
+	`, userID, otherID).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for i, flag := range ClassificationFlags {
+		if comparison.SampleSize == 0 {
+			comparison.FlagAgreement[flag] = nil
+			continue
+		}
+		pct := 100 * float64(counts[i]) / float64(comparison.SampleSize)
+		comparison.FlagAgreement[flag] = &pct
+		total += counts[i]
+	}
+	if comparison.SampleSize > 0 {
+		overall := 100 * float64(total) / float64(comparison.SampleSize*n)
+		comparison.Overall = &overall
+	}
+	return comparison, nil
+}
+
+type GoldAgreement struct {
+	Username string `json:"username"`
+	UserComparison
+}
+
+// GetGoldAgreement compares every other user against the gold-standard
+// user, best overall agreement first; users sharing no transits go last
+func GetGoldAgreement(goldUserID int64) ([]GoldAgreement, error) {
+	users, err := ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []GoldAgreement{}
+	for _, u := range users {
+		if u.ID == goldUserID {
+			continue
+		}
+		comparison, err := CompareUsers(u.ID, goldUserID)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, GoldAgreement{Username: u.Username, UserComparison: *comparison})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].Overall, results[j].Overall
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return *a > *b
+	})
+	return results, nil
+}
+
+type ConfusionMatrix struct {
+	Flag        string `json:"flag"`
+	UserA       int64  `json:"user_a"`
+	UserB       int64  `json:"user_b"`
+	BothTrue    int    `json:"both_true"`
+	ATrueBFalse int    `json:"a_true_b_false"`
+	AFalseBTrue int    `json:"a_false_b_true"`
+	BothFalse   int    `json:"both_false"`
+	ATrue       int    `json:"a_true"`
+	BTrue       int    `json:"b_true"`
+	Total       int    `json:"total"`
+}
+
+// GetConfusionMatrix tallies two users' answers for one flag over the
+// transits both classified. flag must be a column from ClassificationFlags.
+func GetConfusionMatrix(userA, userB int64, flag string) (*ConfusionMatrix, error) {
+	if ResolveFlag(flag) != flag {
+		return nil, fmt.Errorf("unknown flag %q", flag)
+	}
+
+	m := &ConfusionMatrix{Flag: flag, UserA: userA, UserB: userB}
+	a, b := "COALESCE(a."+flag+", 0)", "COALESCE(b."+flag+", 0)"
+	err := db.ReadDB.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN `+a+` AND `+b+` THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN `+a+` AND NOT `+b+` THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN NOT `+a+` AND `+b+` THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN NOT `+a+` AND NOT `+b+` THEN 1 ELSE 0 END), 0)
+		FROM Classifications a
+		JOIN Classifications b
+			ON b.curve_id = a.curve_id AND b.transit_index = a.transit_index
+		WHERE a.user_id = ? AND b.user_id = ?
+	`, userA, userB).Scan(&m.BothTrue, &m.ATrueBFalse, &m.AFalseBTrue, &m.BothFalse)
+	if err != nil {
+		return nil, err
+	}
+
+	m.ATrue = m.BothTrue + m.ATrueBFalse
+	m.BTrue = m.BothTrue + m.AFalseBTrue
+	m.Total = m.BothTrue + m.ATrueBFalse + m.AFalseBTrue + m.BothFalse
+	return m, nil
+}
+
+type FlagMetrics struct {
+	TruePositives  int      `json:"true_positives"`
+	FalsePositives int      `json:"false_positives"`
+	FalseNegatives int      `json:"false_negatives"`
+	Precision      *float64 `json:"precision"`
+	Recall         *float64 `json:"recall"`
+	F1             *float64 `json:"f1"`
+}
+
+type UserFlagMetrics struct {
+	UserID     int64                   `json:"user_id"`
+	SampleSize int                     `json:"sample_size"`
+	Flags      map[string]*FlagMetrics `json:"flags"`
+}
+
+// GetUserFlagMetrics scores the user's answers against the final
+// classifications, per flag, over the transits both cover. Ratios with a
+// zero denominator are nil.
+func GetUserFlagMetrics(userID int64) (*UserFlagMetrics, error) {
+	n := len(ClassificationFlags)
+	sums := make([]string, 0, 3*n)
+	for _, flag := range ClassificationFlags {
+		yours := "COALESCE(cl." + flag + ", 0)"
+		final := "f." + flag
+		sums = append(sums,
+			"COALESCE(SUM("+yours+" AND "+final+"), 0)",
+			"COALESCE(SUM("+yours+" AND NOT "+final+"), 0)",
+			"COALESCE(SUM(NOT "+yours+" AND "+final+"), 0)",
+		)
+	}
+
+	metrics := &UserFlagMetrics{UserID: userID, Flags: make(map[string]*FlagMetrics, n)}
+	counts := make([]FlagMetrics, n)
+	dest := []any{&metrics.SampleSize}
+	for i := range counts {
+		dest = append(dest, &counts[i].TruePositives, &counts[i].FalsePositives, &counts[i].FalseNegatives)
+	}
+
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*), `+strings.Join(sums, ", ")+`
+		FROM Classifications cl
+		JOIN FinalClassifications f ON f.curve_id = cl.curve_id AND f.transit_index = cl.transit_index
+		WHERE cl.user_id = ?
+	`, userID).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := func(num, den int) *float64 {
+		if den == 0 {
+			return nil
+		}
+		r := float64(num) / float64(den)
+		return &r
+	}
+	for i, flag := range ClassificationFlags {
+		m := counts[i]
+		m.Precision = ratio(m.TruePositives, m.TruePositives+m.FalsePositives)
+		m.Recall = ratio(m.TruePositives, m.TruePositives+m.FalseNegatives)
+		// Harmonic mean of precision and recall, written in counts
+		m.F1 = ratio(2*m.TruePositives, 2*m.TruePositives+m.FalsePositives+m.FalseNegatives)
+		metrics.Flags[flag] = &m
+	}
+	return metrics, nil
+}
+
+// MinReliabilityTransits is how many adjudicated transits a user needs
+// before their answers get a reliability weight
+const MinReliabilityTransits = 10
+
+// GetRaterWeights scores each user with enough adjudicated transits by the
+// fraction of flag answers matching the final classifications. Users without
+// a score should be given fallback, the mean of the scores (1 if none).
+func GetRaterWeights() (weights map[int64]float64, fallback float64, err error) {
+	matches := make([]string, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		matches[i] = "(COALESCE(cl." + flag + ", 0) = f." + flag + ")"
+	}
+
+	rows, err := db.ReadDB.Query(`
+		SELECT cl.user_id, COUNT(*), SUM(`+strings.Join(matches, " + ")+`)
+		FROM Classifications cl
+		JOIN FinalClassifications f ON f.curve_id = cl.curve_id AND f.transit_index = cl.transit_index
+		GROUP BY cl.user_id
+		HAVING COUNT(*) >= ?
+	`, MinReliabilityTransits)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	weights = map[int64]float64{}
+	var total float64
+	for rows.Next() {
+		var userID int64
+		var transits, agreed int
+		if err := rows.Scan(&userID, &transits, &agreed); err != nil {
+			return nil, 0, err
+		}
+		w := float64(agreed) / float64(transits*len(ClassificationFlags))
+		weights[userID] = w
+		total += w
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	fallback = 1
+	if len(weights) > 0 {
+		fallback = total / float64(len(weights))
+	}
+	return weights, fallback, nil
+}
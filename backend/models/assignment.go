@@ -0,0 +1,168 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	AssignRoundRobin = "round_robin"
+	AssignAll        = "all"
+)
+
+var (
+	ErrInvalidAssignStrategy = errors.New("invalid assignment strategy")
+	ErrUnknownCurve          = errors.New("unknown curve ID")
+	ErrUnknownUser           = errors.New("unknown user ID")
+)
+
+type UserAssignmentCount struct {
+	UserID   int64 `json:"user_id"`
+	Assigned int   `json:"assigned"`
+	Total    int   `json:"total"`
+}
+
+// BulkAssignCurves assigns curves to users in one transaction. round_robin
+// gives each curve to one user in turn, all gives every curve to every user.
+// Existing assignments are kept; Assigned counts only new ones.
+func BulkAssignCurves(curveIDs, userIDs []int64, strategy string) ([]UserAssignmentCount, error) {
+	if strategy != AssignRoundRobin && strategy != AssignAll {
+		return nil, ErrInvalidAssignStrategy
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkIDsExist(tx, "Curves", curveIDs); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownCurve
+		}
+		return nil, err
+	}
+	if err := checkIDsExist(tx, "Users", userIDs); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO CurveAssignments (curve_id, user_id) VALUES (?, ?)
+		ON CONFLICT(curve_id, user_id) DO NOTHING
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	assigned := make(map[int64]int, len(userIDs))
+	assign := func(curveID, userID int64) error {
+		result, err := stmt.Exec(curveID, userID)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		assigned[userID] += int(n)
+		return err
+	}
+
+	for i, curveID := range curveIDs {
+		if strategy == AssignRoundRobin {
+			if err := assign(curveID, userIDs[i%len(userIDs)]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, userID := range userIDs {
+			if err := assign(curveID, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	counts := []UserAssignmentCount{}
+	seen := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		uc := UserAssignmentCount{UserID: userID, Assigned: assigned[userID]}
+		err := tx.QueryRow("SELECT COUNT(*) FROM CurveAssignments WHERE user_id = ?", userID).Scan(&uc.Total)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, uc)
+	}
+
+	return counts, tx.Commit()
+}
+
+// checkIDsExist returns sql.ErrNoRows if any of ids is missing from table
+func checkIDsExist(tx *sql.Tx, table string, ids []int64) error {
+	unique := make(map[int64]bool, len(ids))
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		if !unique[id] {
+			unique[id] = true
+			args = append(args, id)
+		}
+	}
+
+	var found int
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	err := tx.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id IN ("+placeholders+")", args...).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(args) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SQLite has one writer at a time, but concurrent deferred transactions would
+// fail with "database is locked" rather than wait, so claims queue up here
+var claimMu sync.Mutex
+
+// ClaimNextCurve assigns the caller the first curve (by filename) that has
+// transits and is not assigned to anyone yet. It returns nil when none remain.
+func ClaimNextCurve(userID int64) (*Curve, error) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Select and insert in one statement so no other writer can slip in between
+	var curveID int64
+	err = tx.QueryRow(`
+		INSERT INTO CurveAssignments (curve_id, user_id)
+		SELECT c.id, ? FROM Curves c
+		WHERE c.found_transits > 0
+		AND NOT EXISTS (SELECT 1 FROM CurveAssignments a WHERE a.curve_id = c.id)
+		ORDER BY c.filename
+		LIMIT 1
+		RETURNING curve_id
+	`, userID).Scan(&curveID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetCurveByID(curveID)
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func seedCurves(tb testing.TB, n, foundTransits int) {
+	tb.Helper()
+	for id := 1; id <= n; id++ {
+		dbtest.Exec(tb, `INSERT INTO Curves (id, filename, found_transits) VALUES (?, ?, ?)`,
+			id, fmt.Sprintf("curve%02d", id), foundTransits)
+	}
+}
+
+func TestBulkAssignCurves(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 2)
+	seedCurves(t, 5, 1)
+
+	counts, err := BulkAssignCurves([]int64{1, 2, 3, 4, 5}, []int64{1, 2}, AssignRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UserAssignmentCount{{UserID: 1, Assigned: 3, Total: 3}, {UserID: 2, Assigned: 2, Total: 2}}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("round robin: got %v, want %v", counts, want)
+	}
+
+	// Existing assignments are kept and not counted again
+	counts, err = BulkAssignCurves([]int64{1, 2}, []int64{1, 2, 2}, AssignAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []UserAssignmentCount{{UserID: 1, Assigned: 1, Total: 4}, {UserID: 2, Assigned: 1, Total: 3}}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("all: got %v, want %v", counts, want)
+	}
+
+	failures := []struct {
+		curves, users []int64
+		strategy      string
+		want          error
+	}{
+		{[]int64{1}, []int64{1}, "random", ErrInvalidAssignStrategy},
+		{[]int64{1, 99}, []int64{1}, AssignAll, ErrUnknownCurve},
+		{[]int64{1}, []int64{1, 99}, AssignAll, ErrUnknownUser},
+	}
+	for _, tt := range failures {
+		if _, err := BulkAssignCurves(tt.curves, tt.users, tt.strategy); !errors.Is(err, tt.want) {
+			t.Errorf("BulkAssignCurves(%v, %v, %q): got %v, want %v", tt.curves, tt.users, tt.strategy, err, tt.want)
+		}
+	}
+}
+
+func TestClaimNextCurveConcurrent(t *testing.T) {
+	const curves, claimers = 5, 8
+
+	setupTestDB(t)
+	seedUsers(t, claimers)
+	seedCurves(t, curves, 2)
+	// Curves without transits are never handed out
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename, found_transits) VALUES (100, 'curve00', 0)`)
+
+	claimed := make([]*Curve, claimers)
+	errs := make([]error, claimers)
+	var wg sync.WaitGroup
+	for i := range claimers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed[i], errs[i] = ClaimNextCurve(int64(i + 1))
+		}()
+	}
+	wg.Wait()
+
+	seen := map[int64]bool{}
+	for i, curve := range claimed {
+		if errs[i] != nil {
+			t.Fatalf("claimer %d: %v", i+1, errs[i])
+		}
+		if curve == nil {
+			continue
+		}
+		if curve.ID == 100 {
+			t.Errorf("claimer %d got a curve without transits", i+1)
+		}
+		if seen[curve.ID] {
+			t.Errorf("curve %d claimed twice", curve.ID)
+		}
+		seen[curve.ID] = true
+	}
+	if len(seen) != curves {
+		t.Errorf("%d curves claimed, want all %d", len(seen), curves)
+	}
+
+	if curve, err := ClaimNextCurve(1); curve != nil || err != nil {
+		t.Errorf("claim with none left: got (%v, %v), want (nil, nil)", curve, err)
+	}
+}
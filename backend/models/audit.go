@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+	"time"
+)
+
+type AuditEntry struct {
+	ID        int64  `json:"id"`
+	ActorID   *int64 `json:"actor_id"`
+	Username  string `json:"username"`
+	Action    string `json:"action"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AuditLogOptions filters and pages GetAuditLog; zero values don't filter
+type AuditLogOptions struct {
+	ActorID int64
+	Action  string
+	From    *time.Time
+	To      *time.Time
+	Page    int
+	PerPage int
+}
+
+type AuditLogPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+}
+
+// RecordAudit stores one admin action. action is the method and route
+// pattern, e.g. "DELETE /api/admin/users/:id", and path the concrete URL path.
+func RecordAudit(actorID int64, action, path string, status int) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO AuditLog (actor_id, action, path, status)
+		VALUES (?, ?, ?, ?)
+	`, actorID, action, path, status)
+	return err
+}
+
+// GetAuditLog returns one page of matching entries, newest first, and the
+// total number of matches
+func GetAuditLog(opts AuditLogOptions) (*AuditLogPage, error) {
+	where := "WHERE 1 = 1"
+	var args []any
+	if opts.ActorID != 0 {
+		where += " AND a.actor_id = ?"
+		args = append(args, opts.ActorID)
+	}
+	if opts.Action != "" {
+		where += " AND a.action = ?"
+		args = append(args, opts.Action)
+	}
+	if opts.From != nil {
+		where += " AND a.created_at >= ?"
+		args = append(args, opts.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if opts.To != nil {
+		where += " AND a.created_at < ?"
+		args = append(args, opts.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	page := &AuditLogPage{Entries: []AuditEntry{}, Page: opts.Page, PerPage: opts.PerPage}
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM AuditLog a "+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT a.id, a.actor_id, COALESCE(u.username, ''), a.action, a.path, a.status, a.created_at
+		FROM AuditLog a
+		LEFT JOIN Users u ON a.actor_id = u.id
+		`+where+`
+		ORDER BY a.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, opts.PerPage, (opts.Page-1)*opts.PerPage)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		var actorID sql.NullInt64
+		var createdAt sql.NullString
+		if err := rows.Scan(&e.ID, &actorID, &e.Username, &e.Action, &e.Path, &e.Status, &createdAt); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			e.ActorID = &actorID.Int64
+		}
+		e.CreatedAt = formatDBTimestamp(createdAt.String)
+		page.Entries = append(page.Entries, e)
+	}
+	return page, rows.Err()
+}
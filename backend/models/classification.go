@@ -1,8 +1,15 @@
 package models
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
 	"emoons-web/db"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,6 +30,8 @@ type Classification struct {
 	MarkedTDV           bool       `json:"marked_tdv"`
 	BadModelFit         bool       `json:"bad_model_fit"`
 	Notes               string     `json:"notes"`
+	Tags                []string   `json:"tags,omitempty"`
+	Locked              bool       `json:"locked"`
 	Timestamp           *time.Time `json:"timestamp"`
 }
 
@@ -39,25 +48,120 @@ type ClassificationInput struct {
 	MarkedTDV           bool     `json:"marked_tdv"`
 	BadModelFit         bool     `json:"bad_model_fit"`
 	Notes               string   `json:"notes"`
+	// nil leaves existing tags untouched, an empty list clears them
+	Tags []string `json:"tags"`
 }
 
-func GetClassification(curveID int64, transitIndex int, userID int64) (*Classification, error) {
-	var c Classification
-	var timestamp sql.NullTime
+// Boolean flag columns of Classifications, in display order
+var ClassificationFlags = []string{
+	"normal_transit",
+	"anomalous_morphology",
+	"left_asymmetry",
+	"right_asymmetry",
+	"increased_flux",
+	"decreased_flux",
+	"marked_tdv",
+	"bad_model_fit",
+}
 
-	err := db.DB.QueryRow(`
-		SELECT id, curve_id, transit_index, user_id, t_expected_bjd, t_observed_bjd,
-		       ttv_minutes, left_asymmetry, right_asymmetry, increased_flux,
-		       decreased_flux, normal_transit, anomalous_morphology, marked_tdv,
-		       bad_model_fit, notes, timestamp
-		FROM Classifications
-		WHERE curve_id = ? AND transit_index = ? AND user_id = ?
-	`, curveID, transitIndex, userID).Scan(
+// Pre-rename column names still used in the science team's vocabulary
+var legacyFlagNames = map[string]string{
+	"transito_normal":            "normal_transit",
+	"morfologia_anomala":         "anomalous_morphology",
+	"asimetria_izquierda":        "left_asymmetry",
+	"asimetria_derecha":          "right_asymmetry",
+	"aumento_flujo_interior":     "increased_flux",
+	"disminucion_flujo_interior": "decreased_flux",
+	"tdv_marcada":                "marked_tdv",
+}
+
+// ResolveFlag maps a flag name (current or legacy) to its column, or "" if unknown
+func ResolveFlag(name string) string {
+	if column, ok := legacyFlagNames[name]; ok {
+		return column
+	}
+	for _, flag := range ClassificationFlags {
+		if flag == name {
+			return flag
+		}
+	}
+	return ""
+}
+
+// FlagValues maps each name in ClassificationFlags to the input's value
+func (in *ClassificationInput) FlagValues() map[string]bool {
+	return map[string]bool{
+		"normal_transit":       in.NormalTransit,
+		"anomalous_morphology": in.AnomalousMorphology,
+		"left_asymmetry":       in.LeftAsymmetry,
+		"right_asymmetry":      in.RightAsymmetry,
+		"increased_flux":       in.IncreasedFlux,
+		"decreased_flux":       in.DecreasedFlux,
+		"marked_tdv":           in.MarkedTDV,
+		"bad_model_fit":        in.BadModelFit,
+	}
+}
+
+// Columns scanned by scanClassification, for queries aliasing Classifications as ct
+const classificationColumns = `
+	ct.id, ct.curve_id, ct.transit_index, ct.user_id, ct.t_expected_bjd, ct.t_observed_bjd,
+	ct.ttv_minutes, COALESCE(ct.left_asymmetry, 0), COALESCE(ct.right_asymmetry, 0),
+	COALESCE(ct.increased_flux, 0), COALESCE(ct.decreased_flux, 0), COALESCE(ct.normal_transit, 0),
+	COALESCE(ct.anomalous_morphology, 0), COALESCE(ct.marked_tdv, 0), COALESCE(ct.bad_model_fit, 0),
+	COALESCE(ct.notes, ''), COALESCE(ct.locked, 0), ct.timestamp`
+
+// flagFields maps each name in ClassificationFlags to the classification's field
+func (c *Classification) flagFields() map[string]*bool {
+	return map[string]*bool{
+		"normal_transit":       &c.NormalTransit,
+		"anomalous_morphology": &c.AnomalousMorphology,
+		"left_asymmetry":       &c.LeftAsymmetry,
+		"right_asymmetry":      &c.RightAsymmetry,
+		"increased_flux":       &c.IncreasedFlux,
+		"decreased_flux":       &c.DecreasedFlux,
+		"marked_tdv":           &c.MarkedTDV,
+		"bad_model_fit":        &c.BadModelFit,
+	}
+}
+
+// FlagValues maps each name in ClassificationFlags to the classification's value
+func (c *Classification) FlagValues() map[string]bool {
+	values := make(map[string]bool, len(ClassificationFlags))
+	for flag, field := range c.flagFields() {
+		values[flag] = *field
+	}
+	return values
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanClassification(row rowScanner, c *Classification, extra ...any) error {
+	var timestamp sql.NullTime
+	dest := []any{
 		&c.ID, &c.CurveID, &c.TransitIndex, &c.UserID, &c.TExpectedBJD, &c.TObservedBJD,
 		&c.TTVMinutes, &c.LeftAsymmetry, &c.RightAsymmetry, &c.IncreasedFlux,
 		&c.DecreasedFlux, &c.NormalTransit, &c.AnomalousMorphology, &c.MarkedTDV,
-		&c.BadModelFit, &c.Notes, &timestamp,
-	)
+		&c.BadModelFit, &c.Notes, &c.Locked, &timestamp,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if timestamp.Valid {
+		c.Timestamp = &timestamp.Time
+	}
+	return nil
+}
+
+func GetClassification(curveID int64, transitIndex int, userID int64) (*Classification, error) {
+	var c Classification
+
+	err := scanClassification(db.DB.QueryRow(`
+		SELECT `+classificationColumns+`
+		FROM Classifications ct
+		WHERE ct.curve_id = ? AND ct.transit_index = ? AND ct.user_id = ?
+	`, curveID, transitIndex, userID), &c)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -66,21 +170,157 @@ func GetClassification(curveID int64, transitIndex int, userID int64) (*Classifi
 		return nil, err
 	}
 
-	if timestamp.Valid {
-		c.Timestamp = &timestamp.Time
+	c.Tags, err = GetClassificationTags(c.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	return &c, nil
 }
 
-func SaveClassification(curveID int64, transitIndex int, userID int64, input ClassificationInput) error {
-	_, err := db.DB.Exec(`
+// Upper bound on the IN (...) list, well below SQLite's bound parameter limit
+const MaxClassificationIndices = 500
+
+// GetClassificationsForIndices returns the user's classifications on the
+// given (0-indexed) transits of a curve; unclassified indices are omitted
+func GetClassificationsForIndices(curveID int64, userID int64, indices []int) ([]Classification, error) {
+	classifications := []Classification{}
+	if len(indices) == 0 {
+		return classifications, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(indices)), ", ")
+	args := []any{curveID, userID}
+	for _, index := range indices {
+		args = append(args, index)
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT `+classificationColumns+`
+		FROM Classifications ct
+		WHERE ct.curve_id = ? AND ct.user_id = ? AND ct.transit_index IN (`+placeholders+`)
+		ORDER BY ct.transit_index
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Classification
+		if err := scanClassification(rows, &c); err != nil {
+			return nil, err
+		}
+		classifications = append(classifications, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range classifications {
+		classifications[i].Tags, err = GetClassificationTags(classifications[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return classifications, nil
+}
+
+var (
+	ErrEmptyClassification = errors.New("classification must set at least one flag or include notes or tags")
+	ErrAnomalyNeedsNotes   = errors.New("anomalous morphology requires notes explaining it")
+)
+
+// When set, classifications marking anomalous morphology must include notes
+var RequireNotesForAnomaly bool
+
+// ValidateClassification rejects payloads with no flag set, no notes and no
+// tags, which can't be told apart from a transit nobody looked at, and, under
+// RequireNotesForAnomaly, anomalous morphology without notes
+func ValidateClassification(input ClassificationInput) error {
+	if RequireNotesForAnomaly && input.AnomalousMorphology && strings.TrimSpace(input.Notes) == "" {
+		return ErrAnomalyNeedsNotes
+	}
+	if input.LeftAsymmetry || input.RightAsymmetry ||
+		input.IncreasedFlux || input.DecreasedFlux ||
+		input.NormalTransit || input.AnomalousMorphology ||
+		input.MarkedTDV || input.BadModelFit ||
+		strings.TrimSpace(input.Notes) != "" ||
+		len(NormalizeTags(input.Tags)) > 0 {
+		return nil
+	}
+	return ErrEmptyClassification
+}
+
+var (
+	ErrQuotaReached         = errors.New("classification quota reached")
+	ErrClassificationLocked = errors.New("classification is locked")
+)
+
+// SaveClassification creates or updates the user's classification of a
+// transit. New classifications past the user's quota fail with
+// ErrQuotaReached, and unless includeLocked is set, locked ones fail with
+// ErrClassificationLocked. Both checks are part of the upsert, so concurrent
+// saves can't both take the last slot or slip past a lock being set.
+func SaveClassification(curveID int64, transitIndex int, userID int64, input ClassificationInput, includeLocked bool) error {
+	defer InvalidateUserStats(userID)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertClassification(tx, curveID, transitIndex, userID, input, true, includeLocked); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withinQuota holds when the user may save the classification: it already
+// exists, or the user has no quota or hasn't used it up. It binds enforce,
+// curve_id, transit_index, user_id and user_id again.
+const withinQuota = `(NOT ? OR EXISTS (
+		SELECT 1 FROM Classifications
+		WHERE curve_id = ? AND transit_index = ? AND user_id = ?
+	) OR COALESCE((
+		SELECT u.classification_quota IS NULL
+		    OR u.classification_quota > (SELECT COUNT(*) FROM Classifications WHERE user_id = u.id)
+		FROM Users u WHERE u.id = ?
+	), 1))`
+
+// lockedOrOverQuota explains an upsert that changed nothing: an existing
+// classification is only skipped when locked, a new one only past the quota
+func lockedOrOverQuota(tx *sql.Tx, curveID int64, transitIndex int, userID int64) error {
+	var exists bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM Classifications
+			WHERE curve_id = ? AND transit_index = ? AND user_id = ?
+		)
+	`, curveID, transitIndex, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrClassificationLocked
+	}
+	return ErrQuotaReached
+}
+
+// upsertClassification fails with ErrQuotaReached when enforceQuota is set
+// and the classification would be a new one past the user's quota, and with
+// ErrClassificationLocked when it exists, is locked and includeLocked is unset
+func upsertClassification(tx *sql.Tx, curveID int64, transitIndex int, userID int64, input ClassificationInput, enforceQuota, includeLocked bool) error {
+	result, err := tx.Exec(`
 		INSERT INTO Classifications (
 			curve_id, transit_index, user_id, t_expected_bjd, t_observed_bjd, ttv_minutes,
 			left_asymmetry, right_asymmetry, increased_flux,
 			decreased_flux, normal_transit, anomalous_morphology, marked_tdv,
 			bad_model_fit, notes
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE `+withinQuota+`
 		ON CONFLICT(curve_id, transit_index, user_id) DO UPDATE SET
 			t_expected_bjd = EXCLUDED.t_expected_bjd,
 			t_observed_bjd = EXCLUDED.t_observed_bjd,
@@ -95,30 +335,374 @@ func SaveClassification(curveID int64, transitIndex int, userID int64, input Cla
 			bad_model_fit = EXCLUDED.bad_model_fit,
 			notes = EXCLUDED.notes,
 			timestamp = CURRENT_TIMESTAMP
+		WHERE ? OR NOT COALESCE(locked, 0)
 	`, curveID, transitIndex, userID, input.TExpectedBJD, input.TObservedBJD, input.TTVMinutes,
 		input.LeftAsymmetry, input.RightAsymmetry, input.IncreasedFlux,
 		input.DecreasedFlux, input.NormalTransit, input.AnomalousMorphology,
-		input.MarkedTDV, input.BadModelFit, input.Notes)
+		input.MarkedTDV, input.BadModelFit, input.Notes,
+		enforceQuota, curveID, transitIndex, userID, userID, includeLocked)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return lockedOrOverQuota(tx, curveID, transitIndex, userID)
+	}
+
+	if input.Tags != nil {
+		var id int64
+		err = tx.QueryRow(`
+			SELECT id FROM Classifications
+			WHERE curve_id = ? AND transit_index = ? AND user_id = ?
+		`, curveID, transitIndex, userID).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if err := setClassificationTags(tx, id, input.Tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTransitLocked locks or unlocks every user's classification of a transit
+func SetTransitLocked(curveID int64, transitIndex int, locked bool) (int64, error) {
+	result, err := db.DB.Exec(`
+		UPDATE Classifications SET locked = ?
+		WHERE curve_id = ? AND transit_index = ?
+	`, locked, curveID, transitIndex)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+type ClassificationWithCurve struct {
+	Classification
+	Filename string `json:"filename"`
+}
+
+func SearchClassifications(userID int64, flags []string, matchAll bool) ([]ClassificationWithCurve, error) {
+	// flags must already be resolved through ResolveFlag, so they are safe to interpolate
+	conditions := make([]string, len(flags))
+	for i, flag := range flags {
+		conditions[i] = "ct." + flag + " = 1"
+	}
+	joiner := " OR "
+	if matchAll {
+		joiner = " AND "
+	}
 
-	return err
+	rows, err := db.DB.Query(`
+		SELECT `+classificationColumns+`, c.filename
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		WHERE ct.user_id = ? AND (`+strings.Join(conditions, joiner)+`)
+		ORDER BY c.filename, ct.transit_index
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ClassificationWithCurve{}
+	for rows.Next() {
+		var r ClassificationWithCurve
+		if err := scanClassification(rows, &r.Classification, &r.Filename); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func GetClassificationsAfter(afterID int64, limit int) ([]ClassificationWithCurve, error) {
+	rows, err := db.DB.Query(`
+		SELECT `+classificationColumns+`, c.filename
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		WHERE ct.id > ?
+		ORDER BY ct.id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ClassificationWithCurve{}
+	for rows.Next() {
+		var r ClassificationWithCurve
+		if err := scanClassification(rows, &r.Classification, &r.Filename); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// GetEmptyClassifications lists stored classifications that would fail
+// ValidateClassification, so they can be reviewed and cleaned up
+func GetEmptyClassifications() ([]ClassificationWithCurve, error) {
+	flags := make([]string, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		flags[i] = "COALESCE(ct." + flag + ", 0)"
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT ` + classificationColumns + `, c.filename
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		WHERE NOT (` + strings.Join(flags, " OR ") + `)
+		AND TRIM(COALESCE(ct.notes, '')) = ''
+		AND NOT EXISTS (SELECT 1 FROM ClassificationTags tg WHERE tg.classification_id = ct.id)
+		ORDER BY c.filename, ct.transit_index, ct.user_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ClassificationWithCurve{}
+	for rows.Next() {
+		var r ClassificationWithCurve
+		if err := scanClassification(rows, &r.Classification, &r.Filename); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// orphanCondition matches classifications whose transit no longer exists,
+// e.g. after a CSV re-import dropped it
+const orphanCondition = `NOT EXISTS (
+	SELECT 1 FROM Transits t
+	WHERE t.curve_id = ct.curve_id AND t.transit_index = ct.transit_index + 1
+)`
+
+func FindOrphanClassifications() ([]ClassificationWithCurve, error) {
+	rows, err := db.DB.Query(`
+		SELECT ` + classificationColumns + `, c.filename
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		WHERE ` + orphanCondition + `
+		ORDER BY c.filename, ct.transit_index, ct.user_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ClassificationWithCurve{}
+	for rows.Next() {
+		var r ClassificationWithCurve
+		if err := scanClassification(rows, &r.Classification, &r.Filename); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// ErrNoTransits means the Transits table is empty, so every classification
+// would look orphaned; usually a failed or partial import
+var ErrNoTransits = errors.New("no transits loaded")
+
+// DeleteOrphanClassifications holds the import lock so it never runs against
+// a half-loaded Transits table, and refuses to run when no transits exist
+func DeleteOrphanClassifications() (int64, error) {
+	if err := beginImport(); err != nil {
+		return 0, err
+	}
+	defer importMu.Unlock()
+	defer InvalidateAllUserStats()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var hasTransits bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM Transits)`).Scan(&hasTransits); err != nil {
+		return 0, err
+	}
+	if !hasTransits {
+		return 0, ErrNoTransits
+	}
+
+	result, err := tx.Exec(`DELETE FROM Classifications AS ct WHERE ` + orphanCondition)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+type TransitNote struct {
+	TransitIndex int    `json:"transit_index"`
+	UserID       int64  `json:"user_id"`
+	Username     string `json:"username"`
+	Notes        string `json:"notes"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// GetNotesForCurve returns the non-empty classification notes on a curve,
+// using the 1-indexed transit numbering. Only userID's notes are included
+// unless includeAll is set.
+func GetNotesForCurve(curveID, userID int64, includeAll bool) ([]TransitNote, error) {
+	query := `
+		SELECT ct.transit_index + 1, ct.user_id, u.username, ct.notes, ct.timestamp
+		FROM Classifications ct
+		JOIN Users u ON ct.user_id = u.id
+		WHERE ct.curve_id = ? AND TRIM(COALESCE(ct.notes, '')) != ''`
+	args := []any{curveID}
+	if !includeAll {
+		query += " AND ct.user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY ct.transit_index, ct.timestamp"
+
+	rows, err := db.ReadDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []TransitNote{}
+	for rows.Next() {
+		var n TransitNote
+		var timestamp sql.NullString
+		if err := rows.Scan(&n.TransitIndex, &n.UserID, &n.Username, &n.Notes, &timestamp); err != nil {
+			return nil, err
+		}
+		n.Timestamp = formatDBTimestamp(timestamp.String)
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// Hard cap on SearchNotes results, whatever limit the caller asks for
+const MaxNoteSearchResults = 500
+
+type NoteMatch struct {
+	CurveID      int64  `json:"curve_id"`
+	File         string `json:"file"`
+	TransitIndex int    `json:"transit_index"`
+	PlotFile     string `json:"plot_file"`
+	UserID       int64  `json:"user_id"`
+	Username     string `json:"username"`
+	Notes        string `json:"notes"`
+	Timestamp    string `json:"timestamp"`
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchNotes returns classification notes from every user containing term
+// (case-insensitive), newest first, with 1-indexed transit numbers
+func SearchNotes(term string, limit int) ([]NoteMatch, error) {
+	if limit < 1 || limit > MaxNoteSearchResults {
+		limit = MaxNoteSearchResults
+	}
+
+	rows, err := db.ReadDB.Query(`
+		SELECT ct.curve_id, c.filename, ct.transit_index + 1, COALESCE(t.plot_file, ''),
+			ct.user_id, u.username, ct.notes, ct.timestamp
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		JOIN Users u ON ct.user_id = u.id
+		LEFT JOIN Transits t ON t.curve_id = ct.curve_id AND t.transit_index = ct.transit_index + 1
+		WHERE ct.notes LIKE ? ESCAPE '\'
+		ORDER BY ct.timestamp DESC, ct.id DESC
+		LIMIT ?
+	`, "%"+likeEscaper.Replace(term)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []NoteMatch{}
+	for rows.Next() {
+		var m NoteMatch
+		var timestamp sql.NullString
+		if err := rows.Scan(&m.CurveID, &m.File, &m.TransitIndex, &m.PlotFile,
+			&m.UserID, &m.Username, &m.Notes, &timestamp); err != nil {
+			return nil, err
+		}
+		m.Timestamp = formatDBTimestamp(timestamp.String)
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+type ChangedClassification struct {
+	ClassificationWithCurve
+	Username string `json:"username"`
+}
+
+// GetClassificationsChangedSince returns up to limit classifications saved
+// after the (since, afterID) cursor, oldest first, and whether more remain.
+// Ties on timestamp are broken by id, so polling again from the last row's
+// timestamp and id never skips or repeats rows.
+func GetClassificationsChangedSince(since time.Time, afterID int64, limit int) ([]ChangedClassification, bool, error) {
+	rows, err := db.DB.Query(`
+		SELECT `+classificationColumns+`, c.filename, u.username
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		JOIN Users u ON ct.user_id = u.id
+		WHERE (ct.timestamp, ct.id) > (?, ?)
+		ORDER BY ct.timestamp, ct.id
+		LIMIT ?
+	`, since.UTC().Format("2006-01-02 15:04:05"), afterID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	results := []ChangedClassification{}
+	for rows.Next() {
+		var r ChangedClassification
+		if err := scanClassification(rows, &r.Classification, &r.Filename, &r.Username); err != nil {
+			return nil, false, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(results) <= limit {
+		return results, false, nil
+	}
+	return results[:limit], true, nil
 }
 
 type UserStats struct {
-	TotalClassified int `json:"total_classified"`
-	CurvesCompleted int `json:"curves_completed"`
+	TotalClassified int            `json:"total_classified"`
+	CurvesCompleted int            `json:"curves_completed"`
+	RemainingQuota  *int           `json:"remaining_quota"`
+	CurveVerdicts   map[string]int `json:"curve_verdicts"`
 }
 
-func GetUserStats(userID int64) (*UserStats, error) {
+func GetUserStats(ctx context.Context, userID int64) (*UserStats, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
 	var stats UserStats
 
-	err := db.DB.QueryRow(`
+	err := db.ReadDB.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM Classifications WHERE user_id = ?
 	`, userID).Scan(&stats.TotalClassified)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.DB.QueryRow(`
+	err = db.ReadDB.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM Curves c
 		WHERE c.num_expected_transits > 0
 		AND c.num_expected_transits <= (
@@ -131,22 +715,457 @@ func GetUserStats(userID int64) (*UserStats, error) {
 		return nil, err
 	}
 
+	stats.RemainingQuota, err = GetRemainingQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.CurveVerdicts, err = GetCurveVerdictCounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &stats, nil
 }
 
-func DeleteClassification(curveID int64, transitIndex int, userID int64) error {
-	_, err := db.DB.Exec(`
+type ProjectProgress struct {
+	TotalTransits              int     `json:"total_transits"`
+	TotalClassifiedAtLeastOnce int     `json:"total_classified_at_least_once"`
+	Fraction                   float64 `json:"fraction"`
+}
+
+// GetProjectProgress counts loaded transits classified by at least one user
+func GetProjectProgress(ctx context.Context) (*ProjectProgress, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var p ProjectProgress
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM Transits),
+			(SELECT COUNT(*) FROM Transits t
+			 WHERE EXISTS (
+				SELECT 1 FROM Classifications cl
+				WHERE cl.curve_id = t.curve_id AND cl.transit_index = t.transit_index - 1
+			 ))
+	`).Scan(&p.TotalTransits, &p.TotalClassifiedAtLeastOnce)
+	if err != nil {
+		return nil, err
+	}
+	if p.TotalTransits > 0 {
+		p.Fraction = float64(p.TotalClassifiedAtLeastOnce) / float64(p.TotalTransits)
+	}
+	return &p, nil
+}
+
+type ActivitySnapshot struct {
+	TotalClassifications int       `json:"total_classifications"`
+	ActiveUsers          int       `json:"active_users"`
+	Timestamp            time.Time `json:"timestamp"`
+}
+
+// GetActivitySnapshot counts active (not deactivated) users with a
+// classification saved within window
+func GetActivitySnapshot(window time.Duration) (*ActivitySnapshot, error) {
+	snapshot := ActivitySnapshot{Timestamp: time.Now().UTC()}
+	since := snapshot.Timestamp.Add(-window).Format("2006-01-02 15:04:05")
+
+	err := db.DB.QueryRow(`
+		SELECT
+			COUNT(*),
+			COUNT(DISTINCT CASE WHEN timestamp > ?
+				AND user_id IN (SELECT id FROM Users WHERE active) THEN user_id END)
+		FROM Classifications
+	`, since).Scan(&snapshot.TotalClassifications, &snapshot.ActiveUsers)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+type UserStreak struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+// GetUserStreak counts consecutive days with at least one classification,
+// bucketing timestamps into days in loc. The current streak stays alive
+// until a full day passes without classifying.
+func GetUserStreak(userID int64, loc *time.Location) (*UserStreak, error) {
+	rows, err := db.DB.Query(`
+		SELECT timestamp FROM Classifications
+		WHERE user_id = ? AND timestamp IS NOT NULL
+		ORDER BY timestamp
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
+		}
+		t := ts.In(loc)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if len(days) == 0 || !days[len(days)-1].Equal(day) {
+			days = append(days, day)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	streak := &UserStreak{}
+	run := 0
+	for i, day := range days {
+		if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > streak.Longest {
+			streak.Longest = run
+		}
+	}
+
+	if len(days) > 0 {
+		now := time.Now().In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		last := days[len(days)-1]
+		if last.Equal(today) || last.AddDate(0, 0, 1).Equal(today) {
+			streak.Current = run
+		}
+	}
+	return streak, nil
+}
+
+type ThroughputBucket struct {
+	Hour  string `json:"hour"`
+	Count int    `json:"count"`
+}
+
+type UserThroughput struct {
+	Since             string             `json:"since"`
+	Total             int                `json:"total"`
+	ActiveHours       int                `json:"active_hours"`
+	PerActiveHour     float64            `json:"per_active_hour"`
+	AvgSecondsBetween *float64           `json:"avg_seconds_between"`
+	Hourly            []ThroughputBucket `json:"hourly"`
+}
+
+// GetUserThroughput buckets the user's classifications since the given time
+// into UTC hours. The rate only counts hours with activity so idle periods
+// don't dilute it; the average gap needs at least two classifications.
+func GetUserThroughput(userID int64, since time.Time) (*UserThroughput, error) {
+	rows, err := db.DB.Query(`
+		SELECT timestamp FROM Classifications
+		WHERE user_id = ? AND timestamp >= ?
+		ORDER BY timestamp
+	`, userID, since.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	throughput := &UserThroughput{
+		Since:  since.UTC().Format(time.RFC3339),
+		Hourly: []ThroughputBucket{},
+	}
+	var first, last time.Time
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
+		}
+		ts = ts.UTC()
+		if throughput.Total == 0 {
+			first = ts
+		}
+		last = ts
+		throughput.Total++
+
+		hour := ts.Truncate(time.Hour).Format(time.RFC3339)
+		if n := len(throughput.Hourly); n > 0 && throughput.Hourly[n-1].Hour == hour {
+			throughput.Hourly[n-1].Count++
+		} else {
+			throughput.Hourly = append(throughput.Hourly, ThroughputBucket{Hour: hour, Count: 1})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	throughput.ActiveHours = len(throughput.Hourly)
+	if throughput.ActiveHours > 0 {
+		throughput.PerActiveHour = float64(throughput.Total) / float64(throughput.ActiveHours)
+	}
+	// The mean of consecutive gaps telescopes to the overall span
+	if throughput.Total >= 2 {
+		avg := last.Sub(first).Seconds() / float64(throughput.Total-1)
+		throughput.AvgSecondsBetween = &avg
+	}
+	return throughput, nil
+}
+
+type DataTypeCount struct {
+	DataType        *string `json:"data_type"`
+	ClassifiedCount int     `json:"classified_count"`
+}
+
+func GetClassificationsByDataType(ctx context.Context, userID int64) ([]DataTypeCount, error) {
+	return queryClassificationsByDataType(ctx, "WHERE ct.user_id = ?", userID)
+}
+
+func GetAllClassificationsByDataType(ctx context.Context) ([]DataTypeCount, error) {
+	return queryClassificationsByDataType(ctx, "")
+}
+
+func queryClassificationsByDataType(ctx context.Context, where string, args ...any) ([]DataTypeCount, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	// Curves without a data type (NULL or empty in the CSV) share a single NULL bucket
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT NULLIF(c.data_type, '') AS data_type, COUNT(*)
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		`+where+`
+		GROUP BY NULLIF(c.data_type, '')
+		ORDER BY data_type
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []DataTypeCount{}
+	for rows.Next() {
+		var d DataTypeCount
+		if err := rows.Scan(&d.DataType, &d.ClassifiedCount); err != nil {
+			return nil, err
+		}
+		counts = append(counts, d)
+	}
+	return counts, rows.Err()
+}
+
+type DataTypeStats struct {
+	DataType             *string        `json:"data_type"`
+	TotalTransits        int            `json:"total_transits"`
+	TotalClassifications int            `json:"total_classifications"`
+	FlagCounts           map[string]int `json:"flag_counts"`
+}
+
+// GetStatsByDataType aggregates transits, classifications and flag counts per instrument
+func GetStatsByDataType(ctx context.Context) ([]DataTypeStats, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	n := len(ClassificationFlags)
+	flagSums := make([]string, n)
+	flagTotals := make([]string, n)
+	for i, flag := range ClassificationFlags {
+		flagSums[i] = "SUM(" + flag + ") AS " + flag
+		flagTotals[i] = "COALESCE(SUM(cl." + flag + "), 0)"
+	}
+
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT NULLIF(c.data_type, '') AS data_type,
+			COALESCE(SUM(t.n), 0),
+			COALESCE(SUM(cl.n), 0),
+			`+strings.Join(flagTotals, ",\n\t\t\t")+`
+		FROM Curves c
+		LEFT JOIN (
+			SELECT curve_id, COUNT(*) AS n FROM Transits GROUP BY curve_id
+		) t ON t.curve_id = c.id
+		LEFT JOIN (
+			SELECT curve_id, COUNT(*) AS n, `+strings.Join(flagSums, ", ")+`
+			FROM Classifications GROUP BY curve_id
+		) cl ON cl.curve_id = c.id
+		GROUP BY NULLIF(c.data_type, '')
+		ORDER BY data_type
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]int, n)
+	stats := []DataTypeStats{}
+	for rows.Next() {
+		var d DataTypeStats
+		dest := []any{&d.DataType, &d.TotalTransits, &d.TotalClassifications}
+		for i := range counts {
+			dest = append(dest, &counts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		d.FlagCounts = make(map[string]int, n)
+		for i, flag := range ClassificationFlags {
+			d.FlagCounts[flag] = counts[i]
+		}
+		stats = append(stats, d)
+	}
+	return stats, rows.Err()
+}
+
+type FlagCoOccurrence struct {
+	Flags  []string `json:"flags"`
+	Matrix [][]int  `json:"matrix"`
+}
+
+func GetFlagCoOccurrence(ctx context.Context, userID int64) (*FlagCoOccurrence, error) {
+	return queryFlagCoOccurrence(ctx, "WHERE user_id = ?", userID)
+}
+
+func GetAllFlagCoOccurrence(ctx context.Context) (*FlagCoOccurrence, error) {
+	return queryFlagCoOccurrence(ctx, "")
+}
+
+func queryFlagCoOccurrence(ctx context.Context, where string, args ...any) (*FlagCoOccurrence, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	n := len(ClassificationFlags)
+	result := &FlagCoOccurrence{
+		Flags:  ClassificationFlags,
+		Matrix: make([][]int, n),
+	}
+	for i := range result.Matrix {
+		result.Matrix[i] = make([]int, n)
+	}
+
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT `+strings.Join(ClassificationFlags, ", ")+`
+		FROM Classifications
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]bool, n)
+	dest := make([]any, n)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			if !values[i] {
+				continue
+			}
+			for j := 0; j < n; j++ {
+				if values[j] {
+					result.Matrix[i][j]++
+				}
+			}
+		}
+	}
+	return result, rows.Err()
+}
+
+// GetFlagRates maps each flag to the fraction of the user's classifications carrying it
+func GetFlagRates(ctx context.Context, userID int64) (map[string]float64, error) {
+	return queryFlagRates(ctx, "WHERE user_id = ?", userID)
+}
+
+func GetAllFlagRates(ctx context.Context) (map[string]float64, error) {
+	return queryFlagRates(ctx, "")
+}
+
+func queryFlagRates(ctx context.Context, where string, args ...any) (map[string]float64, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	sums := make([]string, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		sums[i] = "COALESCE(SUM(CASE WHEN " + flag + " THEN 1 ELSE 0 END), 0)"
+	}
+
+	var total int
+	counts := make([]int, len(ClassificationFlags))
+	dest := []any{&total}
+	for i := range counts {
+		dest = append(dest, &counts[i])
+	}
+
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT COUNT(*), `+strings.Join(sums, ", ")+`
+		FROM Classifications
+		`+where, args...).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	// No classifications yields zero rates rather than NaN
+	rates := make(map[string]float64, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		if total > 0 {
+			rates[flag] = float64(counts[i]) / float64(total)
+		} else {
+			rates[flag] = 0
+		}
+	}
+	return rates, nil
+}
+
+// DeleteClassification reports how many rows were removed (0 or 1)
+// DeleteClassification fails with ErrClassificationLocked when the
+// classification is locked and includeLocked is unset
+func DeleteClassification(curveID int64, transitIndex int, userID int64, includeLocked bool) (int64, error) {
+	defer InvalidateUserStats(userID)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
 		DELETE FROM Classifications
-		WHERE curve_id = ? AND transit_index = ? AND user_id = ?
-	`, curveID, transitIndex, userID)
-	return err
+		WHERE curve_id = ? AND transit_index = ? AND user_id = ? AND (? OR NOT COALESCE(locked, 0))
+	`, curveID, transitIndex, userID, includeLocked)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 && !includeLocked {
+		// After the guarded DELETE, the row is only still there if it is locked
+		var locked bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM Classifications
+				WHERE curve_id = ? AND transit_index = ? AND user_id = ?
+			)
+		`, curveID, transitIndex, userID).Scan(&locked)
+		if err != nil {
+			return 0, err
+		}
+		if locked {
+			return 0, ErrClassificationLocked
+		}
+	}
+	return n, tx.Commit()
 }
 
-func DeleteCurveClassifications(curveID int64, userID int64) (int64, error) {
+func DeleteCurveClassifications(curveID int64, userID int64, includeLocked bool) (int64, error) {
+	defer InvalidateUserStats(userID)
+
 	result, err := db.DB.Exec(`
 		DELETE FROM Classifications
-		WHERE curve_id = ? AND user_id = ?
-	`, curveID, userID)
+		WHERE curve_id = ? AND user_id = ? AND (? OR NOT COALESCE(locked, 0))
+	`, curveID, userID, includeLocked)
 	if err != nil {
 		return 0, err
 	}
@@ -169,30 +1188,36 @@ type DetailedUserStats struct {
 	BadModelFit         int    `json:"bad_model_fit"`
 	WithNotes           int    `json:"with_notes"`
 	LastActivity        string `json:"last_activity,omitempty"`
+	// Set when served from the stats cache
+	ComputedAt string `json:"computed_at,omitempty"`
 }
 
-func GetDetailedUserStats(userID int64) (*DetailedUserStats, error) {
+func GetDetailedUserStats(ctx context.Context, userID int64) (*DetailedUserStats, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
 	var stats DetailedUserStats
+	var lastActivity sql.NullString
 
-	err := db.DB.QueryRow(`
+	err := db.ReadDB.QueryRowContext(ctx, `
 		SELECT COUNT(*), COALESCE(SUM(num_expected_transits), 0) FROM Curves WHERE num_expected_transits > 0
 	`).Scan(&stats.TotalCurves, &stats.TotalTransits)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.DB.QueryRow(`
+	err = db.ReadDB.QueryRowContext(ctx, `
 		SELECT
 			COUNT(*),
-			SUM(CASE WHEN normal_transit THEN 1 ELSE 0 END),
-			SUM(CASE WHEN anomalous_morphology THEN 1 ELSE 0 END),
-			SUM(CASE WHEN left_asymmetry THEN 1 ELSE 0 END),
-			SUM(CASE WHEN right_asymmetry THEN 1 ELSE 0 END),
-			SUM(CASE WHEN increased_flux THEN 1 ELSE 0 END),
-			SUM(CASE WHEN decreased_flux THEN 1 ELSE 0 END),
-			SUM(CASE WHEN marked_tdv THEN 1 ELSE 0 END),
-			SUM(CASE WHEN bad_model_fit THEN 1 ELSE 0 END),
-			SUM(CASE WHEN notes != '' THEN 1 ELSE 0 END),
+			COALESCE(SUM(CASE WHEN normal_transit THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN anomalous_morphology THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN left_asymmetry THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN right_asymmetry THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN increased_flux THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN decreased_flux THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN marked_tdv THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN bad_model_fit THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN notes != '' THEN 1 ELSE 0 END), 0),
 			MAX(timestamp)
 		FROM Classifications WHERE user_id = ?
 	`, userID).Scan(
@@ -206,20 +1231,21 @@ func GetDetailedUserStats(userID int64) (*DetailedUserStats, error) {
 		&stats.MarkedTDV,
 		&stats.BadModelFit,
 		&stats.WithNotes,
-		&stats.LastActivity,
+		&lastActivity,
 	)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
+	stats.LastActivity = formatDBTimestamp(lastActivity.String)
 
-	err = db.DB.QueryRow(`
+	err = db.ReadDB.QueryRowContext(ctx, `
 		SELECT COUNT(DISTINCT curve_id) FROM Classifications WHERE user_id = ?
 	`, userID).Scan(&stats.CurvesWithProgress)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.DB.QueryRow(`
+	err = db.ReadDB.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM Curves c
 		WHERE c.num_expected_transits > 0
 		AND c.num_expected_transits <= (
@@ -235,26 +1261,51 @@ func GetDetailedUserStats(userID int64) (*DetailedUserStats, error) {
 	return &stats, nil
 }
 
+// ExportFilter restricts exports to classifications saved in [From, To)
+type ExportFilter struct {
+	From *time.Time
+	To   *time.Time
+}
+
+func (f ExportFilter) conditions() (string, []any) {
+	var where string
+	var args []any
+	if f.From != nil {
+		where += " AND ct.timestamp >= ?"
+		args = append(args, f.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if f.To != nil {
+		where += " AND ct.timestamp < ?"
+		args = append(args, f.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return where, args
+}
+
 type ClassificationExport struct {
-	CurveName           string   `json:"curve_name"`
-	TransitIndex        int      `json:"transit_index"`
-	NormalTransit       bool     `json:"normal_transit"`
-	AnomalousMorphology bool     `json:"anomalous_morphology"`
-	LeftAsymmetry       bool     `json:"left_asymmetry"`
-	RightAsymmetry      bool     `json:"right_asymmetry"`
-	IncreasedFlux       bool     `json:"increased_flux"`
-	DecreasedFlux       bool     `json:"decreased_flux"`
-	MarkedTDV           bool     `json:"marked_tdv"`
-	BadModelFit         bool     `json:"bad_model_fit"`
-	TExpectedBJD        *float64 `json:"t_expected_bjd"`
-	TObservedBJD        *float64 `json:"t_observed_bjd"`
-	TTVMinutes          *float64 `json:"ttv_minutes"`
-	Notes               string   `json:"notes"`
-	Timestamp           string   `json:"timestamp"`
+	CurveName           string       `json:"curve_name"`
+	TransitIndex        int          `json:"transit_index"`
+	NormalTransit       bool         `json:"normal_transit"`
+	AnomalousMorphology bool         `json:"anomalous_morphology"`
+	LeftAsymmetry       bool         `json:"left_asymmetry"`
+	RightAsymmetry      bool         `json:"right_asymmetry"`
+	IncreasedFlux       bool         `json:"increased_flux"`
+	DecreasedFlux       bool         `json:"decreased_flux"`
+	MarkedTDV           bool         `json:"marked_tdv"`
+	BadModelFit         bool         `json:"bad_model_fit"`
+	TExpectedBJD        *float64     `json:"t_expected_bjd"`
+	TObservedBJD        *float64     `json:"t_observed_bjd"`
+	TTVMinutes          *float64     `json:"ttv_minutes"`
+	Notes               string       `json:"notes"`
+	Timestamp           string       `json:"timestamp"`
+	Final               *FinalAnswer `json:"final"`
 }
 
-func GetUserClassificationsForExport(userID int64) ([]ClassificationExport, error) {
-	rows, err := db.DB.Query(`
+func GetUserClassificationsForExport(ctx context.Context, userID int64, filter ExportFilter) ([]ClassificationExport, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	conditions, args := filter.conditions()
+	rows, err := db.ReadDB.QueryContext(ctx, `
 		SELECT
 			c.filename,
 			ct.transit_index,
@@ -270,21 +1321,23 @@ func GetUserClassificationsForExport(userID int64) ([]ClassificationExport, erro
 			ct.t_observed_bjd,
 			ct.ttv_minutes,
 			COALESCE(ct.notes, ''),
-			COALESCE(ct.timestamp, '')
+			COALESCE(ct.timestamp, ''),
+			`+finalExportColumns+`
 		FROM Classifications ct
-		JOIN Curves c ON ct.curve_id = c.id
-		WHERE ct.user_id = ?
+		JOIN Curves c ON ct.curve_id = c.id`+finalExportJoin+`
+		WHERE ct.user_id = ?`+conditions+`
 		ORDER BY c.filename, ct.transit_index
-	`, userID)
+	`, append([]any{userID}, args...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	finalDest, final := finalExportScanner()
 	var exports []ClassificationExport
 	for rows.Next() {
 		var e ClassificationExport
-		if err := rows.Scan(
+		if err := rows.Scan(append([]any{
 			&e.CurveName,
 			&e.TransitIndex,
 			&e.NormalTransit,
@@ -300,10 +1353,136 @@ func GetUserClassificationsForExport(userID int64) ([]ClassificationExport, erro
 			&e.TTVMinutes,
 			&e.Notes,
 			&e.Timestamp,
-		); err != nil {
+		}, finalDest...)...); err != nil {
 			return nil, err
 		}
+		e.Timestamp = formatDBTimestamp(e.Timestamp)
+		e.Final = final()
 		exports = append(exports, e)
 	}
 	return exports, rows.Err()
 }
+
+type RaterClassificationExport struct {
+	UserID   int64  `json:"-"`
+	Username string `json:"username"`
+	ClassificationExport
+}
+
+var raterExportColumns = `
+			u.id,
+			u.username,
+			c.filename,
+			ct.transit_index,
+			ct.normal_transit,
+			ct.anomalous_morphology,
+			ct.left_asymmetry,
+			ct.right_asymmetry,
+			ct.increased_flux,
+			ct.decreased_flux,
+			ct.marked_tdv,
+			ct.bad_model_fit,
+			ct.t_expected_bjd,
+			ct.t_observed_bjd,
+			ct.ttv_minutes,
+			COALESCE(ct.notes, ''),
+			COALESCE(ct.timestamp, ''),
+			` + finalExportColumns
+
+func scanRaterExports(rows *sql.Rows) ([]RaterClassificationExport, error) {
+	defer rows.Close()
+
+	finalDest, final := finalExportScanner()
+	exports := []RaterClassificationExport{}
+	for rows.Next() {
+		var e RaterClassificationExport
+		if err := rows.Scan(append([]any{
+			&e.UserID,
+			&e.Username,
+			&e.CurveName,
+			&e.TransitIndex,
+			&e.NormalTransit,
+			&e.AnomalousMorphology,
+			&e.LeftAsymmetry,
+			&e.RightAsymmetry,
+			&e.IncreasedFlux,
+			&e.DecreasedFlux,
+			&e.MarkedTDV,
+			&e.BadModelFit,
+			&e.TExpectedBJD,
+			&e.TObservedBJD,
+			&e.TTVMinutes,
+			&e.Notes,
+			&e.Timestamp,
+		}, finalDest...)...); err != nil {
+			return nil, err
+		}
+		e.Timestamp = formatDBTimestamp(e.Timestamp)
+		e.Final = final()
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+func GetAllClassificationsForExport(ctx context.Context, filter ExportFilter) ([]RaterClassificationExport, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	conditions, args := filter.conditions()
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT `+raterExportColumns+`
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		JOIN Users u ON ct.user_id = u.id`+finalExportJoin+`
+		WHERE 1 = 1`+conditions+`
+		ORDER BY c.filename, ct.transit_index, u.username
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRaterExports(rows)
+}
+
+// CountUserClassificationsForExport returns the number of rows
+// GetUserClassificationsForExport would produce
+func CountUserClassificationsForExport(ctx context.Context, userID int64, filter ExportFilter) (int, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	conditions, args := filter.conditions()
+	var count int
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		WHERE ct.user_id = ?`+conditions,
+		append([]any{userID}, args...)...).Scan(&count)
+	return count, err
+}
+
+// CountAllClassificationsForExport returns the number of rows
+// GetAllClassificationsForExport would produce
+func CountAllClassificationsForExport(ctx context.Context, filter ExportFilter) (int, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	conditions, args := filter.conditions()
+	var count int
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		JOIN Users u ON ct.user_id = u.id
+		WHERE 1 = 1`+conditions, args...).Scan(&count)
+	return count, err
+}
+
+// AnonymizeRaters replaces usernames with opaque IDs derived from the user ID
+// and key, so the same rater maps to the same ID across rows and exports
+func AnonymizeRaters(exports []RaterClassificationExport, key []byte) {
+	for i := range exports {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(strconv.FormatInt(exports[i].UserID, 10)))
+		exports[i].Username = "user_" + hex.EncodeToString(mac.Sum(nil))[:12]
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+
+	"emoons-web/db"
+)
+
+// ClassificationVersion is one saved state of a user's classification;
+// Timestamp is when that version was saved and Final is always nil
+type ClassificationVersion struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	ClassificationExport
+}
+
+// GetClassificationHistory returns every recorded version of the
+// classifications of a transit (0-indexed), oldest first. A userID of 0
+// includes all users.
+func GetClassificationHistory(ctx context.Context, curveID int64, transitIndex int, userID int64) ([]ClassificationVersion, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT
+			h.user_id,
+			COALESCE(u.username, ''),
+			c.filename,
+			h.transit_index,
+			COALESCE(h.normal_transit, 0),
+			COALESCE(h.anomalous_morphology, 0),
+			COALESCE(h.left_asymmetry, 0),
+			COALESCE(h.right_asymmetry, 0),
+			COALESCE(h.increased_flux, 0),
+			COALESCE(h.decreased_flux, 0),
+			COALESCE(h.marked_tdv, 0),
+			COALESCE(h.bad_model_fit, 0),
+			h.t_expected_bjd,
+			h.t_observed_bjd,
+			h.ttv_minutes,
+			COALESCE(h.notes, ''),
+			COALESCE(h.version_at, '')
+		FROM ClassificationHistory h
+		JOIN Curves c ON h.curve_id = c.id
+		LEFT JOIN Users u ON h.user_id = u.id
+		WHERE h.curve_id = ? AND h.transit_index = ?`
+	args := []any{curveID, transitIndex}
+	if userID != 0 {
+		query += " AND h.user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY h.version_at, h.id"
+
+	rows, err := db.ReadDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []ClassificationVersion{}
+	for rows.Next() {
+		var v ClassificationVersion
+		if err := rows.Scan(
+			&v.UserID,
+			&v.Username,
+			&v.CurveName,
+			&v.TransitIndex,
+			&v.NormalTransit,
+			&v.AnomalousMorphology,
+			&v.LeftAsymmetry,
+			&v.RightAsymmetry,
+			&v.IncreasedFlux,
+			&v.DecreasedFlux,
+			&v.MarkedTDV,
+			&v.BadModelFit,
+			&v.TExpectedBJD,
+			&v.TObservedBJD,
+			&v.TTVMinutes,
+			&v.Notes,
+			&v.Timestamp,
+		); err != nil {
+			return nil, err
+		}
+		v.Timestamp = formatDBTimestamp(v.Timestamp)
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
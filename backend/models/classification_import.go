@@ -0,0 +1,188 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	ImportStatusImported        = "imported"
+	ImportStatusCurveNotFound   = "curve_not_found"
+	ImportStatusTransitNotFound = "transit_not_found"
+	ImportStatusError           = "error"
+)
+
+type ClassificationImportResult struct {
+	Row          int    `json:"row"`
+	Curve        string `json:"curve"`
+	TransitIndex int    `json:"transit_index"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ImportClassificationsCSV upserts classifications for a user from a CSV in
+// the export format (0-indexed transit_index) inside a single transaction.
+// Rows that cannot be imported are reported and skipped; an error is only
+// returned when the file itself is unreadable.
+func ImportClassificationsCSV(userID int64, r io.Reader) ([]ClassificationImportResult, error) {
+	defer InvalidateUserStats(userID)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"curve", "transit_index"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing required column %q", required)
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	curveIDs := make(map[string]int64)
+	results := []ClassificationImportResult{}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		result := ClassificationImportResult{Row: row}
+		if err != nil {
+			// A malformed line only skips itself; anything else means the
+			// body can't be read any further
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("failed to read CSV: %w", err)
+			}
+			result.Status = ImportStatusError
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		result.Curve = field("curve")
+		input, transitIndex, err := parseImportRecord(field)
+		result.TransitIndex = transitIndex
+		if err != nil {
+			result.Status = ImportStatusError
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		curveID, ok := curveIDs[result.Curve]
+		if !ok {
+			err = tx.QueryRow("SELECT id FROM Curves WHERE filename = ?", result.Curve).Scan(&curveID)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			curveIDs[result.Curve] = curveID
+		}
+		if curveID == 0 {
+			result.Status = ImportStatusCurveNotFound
+			results = append(results, result)
+			continue
+		}
+
+		var exists bool
+		err = tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM Transits WHERE curve_id = ? AND transit_index = ?)
+		`, curveID, ToUIIndex(transitIndex)).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			result.Status = ImportStatusTransitNotFound
+			results = append(results, result)
+			continue
+		}
+
+		if err := upsertClassification(tx, curveID, transitIndex, userID, input, false, true); err != nil {
+			result.Status = ImportStatusError
+			result.Error = err.Error()
+		} else {
+			result.Status = ImportStatusImported
+		}
+		results = append(results, result)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func parseImportRecord(field func(string) string) (ClassificationInput, int, error) {
+	var input ClassificationInput
+
+	transitIndex, err := strconv.Atoi(field("transit_index"))
+	if err != nil || transitIndex < 0 {
+		return input, transitIndex, fmt.Errorf("invalid transit_index %q", field("transit_index"))
+	}
+
+	flags := map[string]*bool{
+		"normal_transit":       &input.NormalTransit,
+		"anomalous_morphology": &input.AnomalousMorphology,
+		"left_asymmetry":       &input.LeftAsymmetry,
+		"right_asymmetry":      &input.RightAsymmetry,
+		"increased_flux":       &input.IncreasedFlux,
+		"decreased_flux":       &input.DecreasedFlux,
+		"marked_tdv":           &input.MarkedTDV,
+		"bad_model_fit":        &input.BadModelFit,
+	}
+	for name, dest := range flags {
+		v := field(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return input, transitIndex, fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dest = b
+	}
+
+	floats := map[string]**float64{
+		"t_expected_bjd": &input.TExpectedBJD,
+		"t_observed_bjd": &input.TObservedBJD,
+		"ttv_minutes":    &input.TTVMinutes,
+	}
+	for name, dest := range floats {
+		v := field(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return input, transitIndex, fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dest = &f
+	}
+
+	input.Notes = field("notes")
+	return input, transitIndex, ValidateClassification(input)
+}
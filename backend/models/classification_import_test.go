@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"emoons-web/db"
+	"emoons-web/db/dbtest"
+)
+
+func seedImportTransits(tb testing.TB) {
+	tb.Helper()
+	seedUsers(tb, 1)
+	dbtest.Exec(tb, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(tb, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a_1.png'), (1, 2, 'a_2.png')`)
+}
+
+func countClassifications(tb testing.TB) int {
+	tb.Helper()
+	var n int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM Classifications`).Scan(&n); err != nil {
+		tb.Fatal(err)
+	}
+	return n
+}
+
+func TestImportClassificationsCSVSkipsMalformedRows(t *testing.T) {
+	setupTestDB(t)
+	seedImportTransits(t)
+
+	body := "curve,transit_index,normal_transit\n" +
+		"curveA,0,true\n" +
+		"curveA,1,tr\"ue\n" +
+		"curveB,0,true\n" +
+		"curveA,1,true\n"
+	results, err := ImportClassificationsCSV(1, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{ImportStatusImported, ImportStatusError, ImportStatusCurveNotFound, ImportStatusImported}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for i, status := range want {
+		if results[i].Row != i+1 || results[i].Status != status {
+			t.Errorf("result %d: row %d status %q, want row %d status %q",
+				i, results[i].Row, results[i].Status, i+1, status)
+		}
+	}
+	if n := countClassifications(t); n != 2 {
+		t.Errorf("got %d classifications, want 2", n)
+	}
+}
+
+func TestImportClassificationsCSVReadError(t *testing.T) {
+	setupTestDB(t)
+	seedImportTransits(t)
+
+	// The body fails after a good row, as a dropped upload would
+	body := io.MultiReader(
+		strings.NewReader("curve,transit_index,normal_transit\ncurveA,0,true\n"),
+		iotest.ErrReader(errors.New("connection reset")),
+	)
+	if _, err := ImportClassificationsCSV(1, body); err == nil {
+		t.Fatal("import of an unreadable body succeeded")
+	}
+	if n := countClassifications(t); n != 0 {
+		t.Errorf("failed import left %d classifications, want 0", n)
+	}
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"emoons-web/db"
+)
+
+func TestValidateClassification(t *testing.T) {
+	defer func(require bool) { RequireNotesForAnomaly = require }(RequireNotesForAnomaly)
+
+	tests := []struct {
+		name         string
+		requireNotes bool
+		input        ClassificationInput
+		want         error
+	}{
+		{"empty", false, ClassificationInput{}, ErrEmptyClassification},
+		{"flag", false, ClassificationInput{NormalTransit: true}, nil},
+		{"notes", false, ClassificationInput{Notes: "odd ingress"}, nil},
+		{"whitespace notes", false, ClassificationInput{Notes: " \t\n"}, ErrEmptyClassification},
+		{"tags", false, ClassificationInput{Tags: []string{"spot-crossing"}}, nil},
+		{"blank tags", false, ClassificationInput{Tags: []string{"", "  "}}, ErrEmptyClassification},
+
+		{"anomaly without notes, optional", false, ClassificationInput{AnomalousMorphology: true}, nil},
+		{"anomaly without notes, required", true, ClassificationInput{AnomalousMorphology: true}, ErrAnomalyNeedsNotes},
+		{"anomaly with whitespace notes, required", true,
+			ClassificationInput{AnomalousMorphology: true, Notes: "  \n"}, ErrAnomalyNeedsNotes},
+		{"anomaly with tags only, required", true,
+			ClassificationInput{AnomalousMorphology: true, Tags: []string{"spot-crossing"}}, ErrAnomalyNeedsNotes},
+		{"anomaly with notes, required", true,
+			ClassificationInput{AnomalousMorphology: true, Notes: "double dip"}, nil},
+		{"other flag without notes, required", true, ClassificationInput{LeftAsymmetry: true}, nil},
+		{"empty, required", true, ClassificationInput{}, ErrEmptyClassification},
+	}
+	for _, tt := range tests {
+		RequireNotesForAnomaly = tt.requireNotes
+		if err := ValidateClassification(tt.input); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestSaveClassificationQuota(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 1)
+	seedCurves(t, 1, 4)
+	if err := SetClassificationQuota(1, intPtr(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	input := ClassificationInput{NormalTransit: true}
+	for index := range 2 {
+		if err := SaveClassification(1, index, 1, input, false); err != nil {
+			t.Fatalf("transit %d within quota: %v", index, err)
+		}
+	}
+	if err := SaveClassification(1, 2, 1, input, false); !errors.Is(err, ErrQuotaReached) {
+		t.Errorf("new classification past quota: got %v, want ErrQuotaReached", err)
+	}
+	// Updates stay allowed, even once an admin lowers the quota below the count
+	if err := SetClassificationQuota(1, intPtr(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveClassification(1, 1, 1, ClassificationInput{Notes: "edited"}, false); err != nil {
+		t.Errorf("update past quota: %v", err)
+	}
+	if err := SetClassificationQuota(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveClassification(1, 2, 1, input, false); err != nil {
+		t.Errorf("save without quota: %v", err)
+	}
+}
+
+func TestSaveClassificationQuotaConcurrent(t *testing.T) {
+	const quota, savers = 3, 10
+
+	setupTestDB(t)
+	seedUsers(t, 1)
+	seedCurves(t, 1, savers)
+	if err := SetClassificationQuota(1, intPtr(quota)); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make([]error, savers)
+	var wg sync.WaitGroup
+	for i := range savers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = SaveClassification(1, i, 1, ClassificationInput{NormalTransit: true}, false)
+		}()
+	}
+	wg.Wait()
+
+	saved := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, ErrQuotaReached):
+			t.Errorf("saver %d: %v", i, err)
+		}
+	}
+	var stored int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM Classifications WHERE user_id = 1`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if saved != quota || stored != quota {
+		t.Errorf("%d saves succeeded and %d rows stored, want the quota of %d", saved, stored, quota)
+	}
+}
+
+func TestLockedClassification(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 1)
+	seedCurves(t, 1, 2)
+	if err := SaveClassification(1, 0, 1, ClassificationInput{NormalTransit: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetTransitLocked(1, 0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveClassification(1, 0, 1, ClassificationInput{Notes: "changed"}, false); !errors.Is(err, ErrClassificationLocked) {
+		t.Errorf("saving a locked classification: got %v, want ErrClassificationLocked", err)
+	}
+	// A locked row is reported as locked even when the user is past the quota
+	if err := SetClassificationQuota(1, intPtr(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveClassification(1, 0, 1, ClassificationInput{Notes: "changed"}, false); !errors.Is(err, ErrClassificationLocked) {
+		t.Errorf("saving a locked classification past quota: got %v, want ErrClassificationLocked", err)
+	}
+	if err := SetClassificationQuota(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cl, err := GetClassification(1, 0, 1); err != nil || cl == nil || cl.Notes != "" {
+		t.Fatalf("refused saves changed the classification: got (%+v, %v)", cl, err)
+	}
+
+	if err := SaveClassification(1, 0, 1, ClassificationInput{Notes: "reviewed"}, true); err != nil {
+		t.Errorf("saving a locked classification with includeLocked: %v", err)
+	}
+	if err := SaveClassification(1, 1, 1, ClassificationInput{NormalTransit: true}, false); err != nil {
+		t.Errorf("saving an unlocked transit: %v", err)
+	}
+
+	if n, err := DeleteClassification(1, 0, 1, false); !errors.Is(err, ErrClassificationLocked) || n != 0 {
+		t.Errorf("deleting a locked classification: got (%d, %v), want ErrClassificationLocked", n, err)
+	}
+	if n, err := DeleteClassification(1, 0, 1, true); err != nil || n != 1 {
+		t.Errorf("deleting a locked classification with includeLocked: got (%d, %v), want 1", n, err)
+	}
+	if n, err := DeleteClassification(1, 0, 1, false); err != nil || n != 0 {
+		t.Errorf("deleting a missing classification: got (%d, %v), want (0, nil)", n, err)
+	}
+}
+
+func TestClassificationFlagFields(t *testing.T) {
+	var cl Classification
+	fields := cl.flagFields()
+	if len(fields) != len(ClassificationFlags) {
+		t.Errorf("%d flag fields for %d flags", len(fields), len(ClassificationFlags))
+	}
+	seen := make(map[*bool]string)
+	for _, flag := range ClassificationFlags {
+		field, ok := fields[flag]
+		if !ok {
+			t.Errorf("flag %s has no field", flag)
+			continue
+		}
+		if other, dup := seen[field]; dup {
+			t.Errorf("flags %s and %s share a field", other, flag)
+		}
+		seen[field] = flag
+	}
+}
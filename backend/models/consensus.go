@@ -0,0 +1,524 @@
+package models
+
+import (
+	"emoons-web/db"
+	"strings"
+)
+
+type TransitConsensus struct {
+	CurveID      int64          `json:"curve_id"`
+	TransitIndex int            `json:"transit_index"`
+	Raters       int            `json:"raters"`
+	FlagVotes    map[string]int `json:"flag_votes"`
+	// Only set by a weighted tally: the raters' summed reliability weights
+	TotalWeight   float64            `json:"total_weight,omitempty"`
+	WeightedVotes map[string]float64 `json:"weighted_votes,omitempty"`
+}
+
+// Majority returns the strict-majority answer for a flag, by weight when the
+// tally is weighted; ok is false on a tie
+func (t TransitConsensus) Majority(flag string) (value bool, ok bool) {
+	if t.WeightedVotes != nil {
+		votes := t.WeightedVotes[flag]
+		switch {
+		case 2*votes > t.TotalWeight:
+			return true, true
+		case 2*votes < t.TotalWeight:
+			return false, true
+		}
+		return false, false
+	}
+
+	votes := t.FlagVotes[flag]
+	switch {
+	case 2*votes > t.Raters:
+		return true, true
+	case 2*votes < t.Raters:
+		return false, true
+	}
+	return false, false
+}
+
+// Decisions maps each flag to its majority answer, nil on a tie
+func (t TransitConsensus) Decisions() map[string]*bool {
+	decisions := make(map[string]*bool, len(ClassificationFlags))
+	for _, flag := range ClassificationFlags {
+		if value, ok := t.Majority(flag); ok {
+			decisions[flag] = &value
+		} else {
+			decisions[flag] = nil
+		}
+	}
+	return decisions
+}
+
+// DefaultClassification returns an unsaved classification of the transit
+// with every flag set to its majority answer (false on a tie)
+func (t TransitConsensus) DefaultClassification() *Classification {
+	cl := &Classification{CurveID: t.CurveID, TransitIndex: t.TransitIndex}
+	fields := cl.flagFields()
+	for _, flag := range ClassificationFlags {
+		*fields[flag], _ = t.Majority(flag)
+	}
+	return cl
+}
+
+// GetTransitConsensus tallies one (0-indexed) transit, or returns nil when
+// nobody has classified it
+func GetTransitConsensus(curveID int64, transitIndex int) (*TransitConsensus, error) {
+	consensus, err := tallyConsensus(curveID, "curve_id = ? AND transit_index = ?",
+		[]any{curveID, transitIndex}, 1)
+	if err != nil || len(consensus) == 0 {
+		return nil, err
+	}
+	return &consensus[0], nil
+}
+
+// GetConsensusForCurve tallies flag votes per transit of a curve, keeping
+// only transits classified by at least minRaters users. When weighted is set
+// each vote also counts with its rater's reliability weight.
+func GetConsensusForCurve(curveID int64, minRaters int, weighted bool) ([]TransitConsensus, error) {
+	if weighted {
+		return getWeightedConsensusForCurve(curveID, minRaters)
+	}
+	return tallyConsensus(curveID, "curve_id = ?", []any{curveID}, minRaters)
+}
+
+// tallyConsensus counts flag votes per transit over the classifications
+// matching where, a condition on curveID's rows
+func tallyConsensus(curveID int64, where string, args []any, minRaters int) ([]TransitConsensus, error) {
+	n := len(ClassificationFlags)
+	sums := make([]string, n)
+	for i, flag := range ClassificationFlags {
+		sums[i] = "SUM(COALESCE(" + flag + ", 0))"
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT transit_index, COUNT(*), `+strings.Join(sums, ", ")+`
+		FROM Classifications
+		WHERE `+where+`
+		GROUP BY transit_index
+		HAVING COUNT(*) >= ?
+		ORDER BY transit_index
+	`, append(args, minRaters)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := make([]int, n)
+	consensus := []TransitConsensus{}
+	for rows.Next() {
+		t := TransitConsensus{CurveID: curveID}
+		dest := []any{&t.TransitIndex, &t.Raters}
+		for i := range votes {
+			dest = append(dest, &votes[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		t.FlagVotes = make(map[string]int, n)
+		for i, flag := range ClassificationFlags {
+			t.FlagVotes[flag] = votes[i]
+		}
+		consensus = append(consensus, t)
+	}
+	return consensus, rows.Err()
+}
+
+func getWeightedConsensusForCurve(curveID int64, minRaters int) ([]TransitConsensus, error) {
+	weights, fallback, err := GetRaterWeights()
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(ClassificationFlags)
+	columns := make([]string, n)
+	for i, flag := range ClassificationFlags {
+		columns[i] = "COALESCE(" + flag + ", 0)"
+	}
+
+	rows, err := db.ReadDB.Query(`
+		SELECT transit_index, user_id, `+strings.Join(columns, ", ")+`
+		FROM Classifications
+		WHERE curve_id = ?
+		ORDER BY transit_index
+	`, curveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]bool, n)
+	var tallies []*TransitConsensus
+	for rows.Next() {
+		var index int
+		var userID int64
+		dest := []any{&index, &userID}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if len(tallies) == 0 || tallies[len(tallies)-1].TransitIndex != index {
+			tallies = append(tallies, &TransitConsensus{
+				CurveID:       curveID,
+				TransitIndex:  index,
+				FlagVotes:     make(map[string]int, n),
+				WeightedVotes: make(map[string]float64, n),
+			})
+		}
+		t := tallies[len(tallies)-1]
+
+		weight, ok := weights[userID]
+		if !ok {
+			weight = fallback
+		}
+		t.Raters++
+		t.TotalWeight += weight
+		for i, flag := range ClassificationFlags {
+			if values[i] {
+				t.FlagVotes[flag]++
+				t.WeightedVotes[flag] += weight
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	consensus := []TransitConsensus{}
+	for _, t := range tallies {
+		if t.Raters >= minRaters {
+			consensus = append(consensus, *t)
+		}
+	}
+	return consensus, nil
+}
+
+type ConsensusDiff struct {
+	Flag      string `json:"flag"`
+	Yours     bool   `json:"yours"`
+	Consensus bool   `json:"consensus"`
+	Votes     int    `json:"votes"`
+}
+
+type ConsensusDivergence struct {
+	CurveID      int64           `json:"curve_id"`
+	Filename     string          `json:"filename"`
+	TransitIndex int             `json:"transit_index"` // 1-indexed, as in Transits
+	Raters       int             `json:"raters"`
+	Diffs        []ConsensusDiff `json:"diffs"`
+}
+
+// forEachUserConsensus calls fn with each of the user's classifications on a
+// transit that has at least minRaters raters, along with that transit's tally
+func forEachUserConsensus(userID int64, minRaters int, fn func(cl ClassificationWithCurve, t TransitConsensus)) error {
+	rows, err := db.DB.Query(`
+		SELECT `+classificationColumns+`, c.filename
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		WHERE ct.user_id = ?
+		ORDER BY c.filename, ct.transit_index
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var own []ClassificationWithCurve
+	for rows.Next() {
+		var cl ClassificationWithCurve
+		if err := scanClassification(rows, &cl.Classification, &cl.Filename); err != nil {
+			return err
+		}
+		own = append(own, cl)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	consensusByCurve := map[int64]map[int]TransitConsensus{}
+	for _, cl := range own {
+		byIndex, ok := consensusByCurve[cl.CurveID]
+		if !ok {
+			consensus, err := GetConsensusForCurve(cl.CurveID, minRaters, false)
+			if err != nil {
+				return err
+			}
+			byIndex = make(map[int]TransitConsensus, len(consensus))
+			for _, t := range consensus {
+				byIndex[t.TransitIndex] = t
+			}
+			consensusByCurve[cl.CurveID] = byIndex
+		}
+
+		if t, ok := byIndex[cl.TransitIndex]; ok {
+			fn(cl, t)
+		}
+	}
+	return nil
+}
+
+// GetUserVsConsensus lists the user's classifications that differ from the
+// majority on at least one flag, for transits with at least minRaters raters
+func GetUserVsConsensus(userID int64, minRaters int) ([]ConsensusDivergence, error) {
+	divergences := []ConsensusDivergence{}
+	err := forEachUserConsensus(userID, minRaters, func(cl ClassificationWithCurve, t TransitConsensus) {
+		flags := cl.FlagValues()
+		var diffs []ConsensusDiff
+		for _, flag := range ClassificationFlags {
+			majority, ok := t.Majority(flag)
+			if ok && majority != flags[flag] {
+				diffs = append(diffs, ConsensusDiff{Flag: flag, Yours: flags[flag], Consensus: majority, Votes: t.FlagVotes[flag]})
+			}
+		}
+		if len(diffs) > 0 {
+			divergences = append(divergences, ConsensusDivergence{
+				CurveID:      cl.CurveID,
+				Filename:     cl.Filename,
+				TransitIndex: ToUIIndex(cl.TransitIndex),
+				Raters:       t.Raters,
+				Diffs:        diffs,
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return divergences, nil
+}
+
+type FlagAgreement struct {
+	Agreed   int      `json:"agreed"`
+	Total    int      `json:"total"`
+	Fraction *float64 `json:"fraction"`
+}
+
+// GetUserConsensusAgreement maps each flag to how often the user's answer
+// matched the majority, over transits with at least minRaters raters where
+// that flag's vote was not tied. Fraction is nil when Total is zero.
+func GetUserConsensusAgreement(userID int64, minRaters int) (map[string]*FlagAgreement, error) {
+	agreement := make(map[string]*FlagAgreement, len(ClassificationFlags))
+	for _, flag := range ClassificationFlags {
+		agreement[flag] = &FlagAgreement{}
+	}
+
+	err := forEachUserConsensus(userID, minRaters, func(cl ClassificationWithCurve, t TransitConsensus) {
+		flags := cl.FlagValues()
+		for _, flag := range ClassificationFlags {
+			majority, ok := t.Majority(flag)
+			if !ok {
+				continue
+			}
+			agreement[flag].Total++
+			if majority == flags[flag] {
+				agreement[flag].Agreed++
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range agreement {
+		if a.Total > 0 {
+			f := float64(a.Agreed) / float64(a.Total)
+			a.Fraction = &f
+		}
+	}
+	return agreement, nil
+}
+
+// MinCalibrationTransits is how many consensus transits a user needs before
+// a calibration score is reported
+const MinCalibrationTransits = 10
+
+// UserCalibration scores how well a user's answers track the consensus.
+// Every non-tied flag majority is one decision; weighted agreement weights
+// each decision by the majority's margin, so disagreeing with a near
+// unanimous majority costs more than disagreeing with a narrow one. Score is
+// the weighted agreement, and is nil when InsufficientData is set.
+type UserCalibration struct {
+	TransitsCompared  int      `json:"transits_compared"`
+	FlagDecisions     int      `json:"flag_decisions"`
+	AgreementRate     *float64 `json:"agreement_rate"`
+	WeightedAgreement *float64 `json:"weighted_agreement"`
+	PositiveCalls     int      `json:"positive_calls"`
+	PositiveAgreement *float64 `json:"positive_agreement"`
+	Score             *float64 `json:"score"`
+	InsufficientData  bool     `json:"insufficient_data"`
+	MinTransits       int      `json:"min_transits"`
+}
+
+func GetUserCalibration(userID int64, minRaters int) (*UserCalibration, error) {
+	cal := &UserCalibration{MinTransits: MinCalibrationTransits}
+	var agreed, positiveAgreed int
+	var weight, weightedAgreed float64
+	err := forEachUserConsensus(userID, minRaters, func(cl ClassificationWithCurve, t TransitConsensus) {
+		cal.TransitsCompared++
+		flags := cl.FlagValues()
+		for _, flag := range ClassificationFlags {
+			majority, ok := t.Majority(flag)
+			if !ok {
+				continue
+			}
+			votes := t.FlagVotes[flag]
+			margin := float64(2*votes-t.Raters) / float64(t.Raters)
+			if margin < 0 {
+				margin = -margin
+			}
+
+			cal.FlagDecisions++
+			weight += margin
+			if flags[flag] {
+				cal.PositiveCalls++
+			}
+			if majority == flags[flag] {
+				agreed++
+				weightedAgreed += margin
+				if flags[flag] {
+					positiveAgreed++
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := func(num, den float64) *float64 {
+		if den == 0 {
+			return nil
+		}
+		r := num / den
+		return &r
+	}
+	cal.AgreementRate = ratio(float64(agreed), float64(cal.FlagDecisions))
+	cal.WeightedAgreement = ratio(weightedAgreed, weight)
+	cal.PositiveAgreement = ratio(float64(positiveAgreed), float64(cal.PositiveCalls))
+
+	cal.InsufficientData = cal.TransitsCompared < MinCalibrationTransits || cal.WeightedAgreement == nil
+	if !cal.InsufficientData {
+		cal.Score = cal.WeightedAgreement
+	}
+	return cal, nil
+}
+
+type CurveFlagHeatmapRow struct {
+	CurveID       int64              `json:"curve_id"`
+	Filename      string             `json:"filename"`
+	Transits      int                `json:"transits"`
+	FlagFractions map[string]float64 `json:"flag_fractions"`
+}
+
+// GetCurveFlagHeatmap returns, for a page of curves ordered by id, the
+// fraction of each curve's transits whose raters set a flag by strict
+// majority. Unclassified transits count as not carrying any flag.
+func GetCurveFlagHeatmap(afterID int64, limit int) ([]CurveFlagHeatmapRow, error) {
+	n := len(ClassificationFlags)
+	voteSums := make([]string, n)
+	majorities := make([]string, n)
+	for i, flag := range ClassificationFlags {
+		voteSums[i] = "SUM(" + flag + ") AS " + flag
+		majorities[i] = "COALESCE(SUM(CASE WHEN 2 * v." + flag + " > v.raters THEN 1 ELSE 0 END), 0)"
+	}
+
+	rows, err := db.DB.Query(`
+		WITH page AS (
+			SELECT id, filename FROM Curves WHERE id > ? ORDER BY id LIMIT ?
+		),
+		votes AS (
+			SELECT curve_id, transit_index, COUNT(*) AS raters, `+strings.Join(voteSums, ", ")+`
+			FROM Classifications
+			WHERE curve_id IN (SELECT id FROM page)
+			GROUP BY curve_id, transit_index
+		)
+		SELECT p.id, p.filename,
+			(SELECT COUNT(*) FROM Transits t WHERE t.curve_id = p.id),
+			`+strings.Join(majorities, ", ")+`
+		FROM page p
+		LEFT JOIN votes v ON v.curve_id = p.id
+		GROUP BY p.id
+		ORDER BY p.id
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]int, n)
+	heatmap := []CurveFlagHeatmapRow{}
+	for rows.Next() {
+		var r CurveFlagHeatmapRow
+		dest := []any{&r.CurveID, &r.Filename, &r.Transits}
+		for i := range counts {
+			dest = append(dest, &counts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		r.FlagFractions = make(map[string]float64, n)
+		for i, flag := range ClassificationFlags {
+			r.FlagFractions[flag] = 0
+			if r.Transits > 0 {
+				r.FlagFractions[flag] = float64(counts[i]) / float64(r.Transits)
+			}
+		}
+		heatmap = append(heatmap, r)
+	}
+	return heatmap, rows.Err()
+}
+
+type CurveRaterDeficit struct {
+	CurveID             int64  `json:"curve_id"`
+	Filename            string `json:"filename"`
+	TransitsBelowTarget int    `json:"transits_below_target"`
+	Deficit             int    `json:"deficit"`
+}
+
+type RaterDeficit struct {
+	MinRaters           int                 `json:"min_raters"`
+	TransitsBelowTarget int                 `json:"transits_below_target"`
+	TotalDeficit        int                 `json:"total_deficit"`
+	Curves              []CurveRaterDeficit `json:"curves,omitempty"`
+}
+
+// GetRaterDeficit counts transits rated by fewer than minRaters users and
+// how many more ratings it takes to bring them all up to the target,
+// broken down per curve
+func GetRaterDeficit(minRaters int) (*RaterDeficit, error) {
+	rows, err := db.ReadDB.Query(`
+		SELECT c.id, c.filename, COUNT(*), SUM(? - r.raters)
+		FROM (
+			SELECT t.curve_id, (
+				SELECT COUNT(DISTINCT cl.user_id) FROM Classifications cl
+				WHERE cl.curve_id = t.curve_id AND cl.transit_index = t.transit_index - 1
+			) AS raters
+			FROM Transits t
+		) r
+		JOIN Curves c ON c.id = r.curve_id
+		WHERE r.raters < ?
+		GROUP BY c.id
+		ORDER BY c.filename
+	`, minRaters, minRaters)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deficit := &RaterDeficit{MinRaters: minRaters, Curves: []CurveRaterDeficit{}}
+	for rows.Next() {
+		var d CurveRaterDeficit
+		if err := rows.Scan(&d.CurveID, &d.Filename, &d.TransitsBelowTarget, &d.Deficit); err != nil {
+			return nil, err
+		}
+		deficit.TransitsBelowTarget += d.TransitsBelowTarget
+		deficit.TotalDeficit += d.Deficit
+		deficit.Curves = append(deficit.Curves, d)
+	}
+	return deficit, rows.Err()
+}
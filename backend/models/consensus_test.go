@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestGetTransitConsensus(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 3)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit, left_asymmetry) VALUES
+		(1, 0, 1, 1, 0), (1, 0, 2, 1, 0), (1, 0, 3, 0, 1),
+		(1, 1, 1, 0, 1)`)
+
+	got, err := GetTransitConsensus(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.TransitIndex != 0 || got.Raters != 3 ||
+		got.FlagVotes["normal_transit"] != 2 || got.FlagVotes["left_asymmetry"] != 1 {
+		t.Errorf("transit 0: got %+v", got)
+	}
+	defaults := got.DefaultClassification()
+	if !defaults.NormalTransit || defaults.LeftAsymmetry {
+		t.Errorf("transit 0 defaults: got %+v", defaults)
+	}
+
+	if got, err := GetTransitConsensus(1, 2); err != nil || got != nil {
+		t.Errorf("unclassified transit: got (%+v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestGetUserVsConsensus(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 3)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit, left_asymmetry) VALUES
+		(1, 1, 1, 1, 0), (1, 1, 2, 1, 0), (1, 1, 3, 0, 1)`)
+
+	if got, err := GetUserVsConsensus(1, 3); err != nil || len(got) != 0 {
+		t.Errorf("user in the majority: got (%+v, %v), want no divergences", got, err)
+	}
+
+	got, err := GetUserVsConsensus(3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d divergences, want 1: %+v", len(got), got)
+	}
+	if d := got[0]; d.Filename != "curveA" || d.TransitIndex != 2 || d.Raters != 3 || len(d.Diffs) != 2 {
+		t.Errorf("got %+v, want transit 2 of curveA with 3 raters and 2 differing flags", d)
+	}
+}
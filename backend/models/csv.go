@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// CSVFormat describes how the curves and transits CSVs are written upstream
+type CSVFormat struct {
+	Delimiter rune
+	// nil means UTF-8
+	Encoding encoding.Encoding
+}
+
+var CSVImportFormat = CSVFormat{Delimiter: ','}
+
+// ParseCSVFormat builds a CSVFormat from a single-character delimiter ("tab"
+// is accepted for tabs) and a WHATWG encoding label such as "latin1" or
+// "windows-1252". Empty values mean comma and UTF-8.
+func ParseCSVFormat(delimiter, encodingName string) (CSVFormat, error) {
+	format := CSVFormat{Delimiter: ','}
+
+	switch {
+	case delimiter == "":
+	case strings.EqualFold(delimiter, "tab"):
+		format.Delimiter = '\t'
+	case utf8.RuneCountInString(delimiter) == 1:
+		format.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	default:
+		return format, fmt.Errorf("delimiter %q must be a single character", delimiter)
+	}
+
+	if encodingName != "" {
+		enc, err := htmlindex.Get(encodingName)
+		if err != nil {
+			return format, fmt.Errorf("unsupported encoding %q", encodingName)
+		}
+		if name, _ := htmlindex.Name(enc); name != "utf-8" {
+			format.Encoding = enc
+		}
+	}
+	return format, nil
+}
+
+func (f CSVFormat) newReader(r io.Reader) *csv.Reader {
+	if f.Encoding != nil {
+		r = f.Encoding.NewDecoder().Reader(r)
+	}
+	reader := csv.NewReader(r)
+	reader.Comma = f.Delimiter
+	return reader
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestParseCSVFormat(t *testing.T) {
+	tests := []struct {
+		delimiter, encoding string
+		wantDelimiter       rune
+		wantDecoded         bool
+		wantErr             bool
+	}{
+		{"", "", ',', false, false},
+		{";", "", ';', false, false},
+		{"TAB", "", '\t', false, false},
+		{"", "utf-8", ',', false, false},
+		{";", "latin1", ';', true, false},
+		{"", "windows-1252", ',', true, false},
+		{";;", "", 0, false, true},
+		{"", "klingon", 0, false, true},
+	}
+	for _, tt := range tests {
+		format, err := ParseCSVFormat(tt.delimiter, tt.encoding)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCSVFormat(%q, %q): error %v", tt.delimiter, tt.encoding, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if format.Delimiter != tt.wantDelimiter || (format.Encoding != nil) != tt.wantDecoded {
+			t.Errorf("ParseCSVFormat(%q, %q) = %+v", tt.delimiter, tt.encoding, format)
+		}
+	}
+}
+
+func TestLoadCSVWithFormat(t *testing.T) {
+	defer func(format CSVFormat) { CSVImportFormat = format }(CSVImportFormat)
+
+	tests := []struct {
+		name     string
+		format   CSVFormat
+		curves   string
+		transits string
+	}{
+		{"semicolon", CSVFormat{Delimiter: ';'}, "testdata/curves_semicolon.csv", "testdata/transits_semicolon.csv"},
+		// windows-1252 is what the WHATWG "latin1" label resolves to
+		{"latin1", CSVFormat{Delimiter: ',', Encoding: charmap.Windows1252}, "testdata/curves_latin1.csv", "testdata/transits_latin1.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			CSVImportFormat = tt.format
+
+			if err := ReloadFromCSV(tt.curves, tt.transits); err != nil {
+				t.Fatal(err)
+			}
+
+			curve, err := GetCurveByFilename("curvé_a")
+			if err != nil || curve == nil {
+				t.Fatalf("curve with accented name: got (%v, %v)", curve, err)
+			}
+			if curve.DataType == nil || *curve.DataType != "Señal TESS" {
+				t.Errorf("data type %v, want %q", curve.DataType, "Señal TESS")
+			}
+			if curve.FoundTransits != 2 {
+				t.Errorf("found_transits %d, want 2", curve.FoundTransits)
+			}
+			transit := GetTransit("curvé_a", 2)
+			if transit == nil || transit.PlotFile != "curvé_a_2.png" || transit.T0Expected != 4.0 {
+				t.Errorf("transit 2: got %+v", transit)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package models
 
 import (
 	"database/sql"
-	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
@@ -27,21 +26,27 @@ type Curve struct {
 	InclinationDeg      *float64 `json:"inclination_deg"`
 	U1                  *float64 `json:"u1"`
 	U2                  *float64 `json:"u2"`
+	Notes               string   `json:"curve_notes"` // admin-only edit, read-only for classifiers
 }
 
 type CurveWithProgress struct {
 	Curve
-	ClassifiedCount int `json:"classified_count"`
+	ClassifiedCount int  `json:"classified_count"`
+	MarkedComplete  bool `json:"marked_complete"`
 }
 
-func LoadCurvesFromCSV(csvPath string) error {
+func loadCurvesFromCSV(csvPath string) error {
+	// Runs even on partial failure, since earlier batches may already be committed
+	defer InvalidateCurveCache()
+	defer InvalidateAllUserStats()
+
 	file, err := os.Open(csvPath)
 	if err != nil {
 		return fmt.Errorf("failed to open CSV: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	reader := CSVImportFormat.newReader(file)
 	records, err := reader.ReadAll()
 	if err != nil {
 		return fmt.Errorf("failed to read CSV: %w", err)
@@ -134,10 +139,10 @@ func LoadCurvesFromCSV(csvPath string) error {
 }
 
 func GetAllCurves() ([]Curve, error) {
-	rows, err := db.DB.Query(`
+	rows, err := db.ReadDB.Query(`
 		SELECT id, filename, time_min, time_max,
 		       num_expected_transits, found_transits, data_type, period_days, epoch_bjd,
-		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2
+		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2, COALESCE(curve_notes, '')
 		FROM Curves
 		ORDER BY filename
 	`)
@@ -152,7 +157,7 @@ func GetAllCurves() ([]Curve, error) {
 		err := rows.Scan(
 			&c.ID, &c.Filename, &c.TimeMin, &c.TimeMax,
 			&c.NumExpectedTransits, &c.FoundTransits, &c.DataType, &c.PeriodDays, &c.EpochBJD,
-			&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2,
+			&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2, &c.Notes,
 		)
 		if err != nil {
 			return nil, err
@@ -162,16 +167,49 @@ func GetAllCurves() ([]Curve, error) {
 	return curves, nil
 }
 
+// GetEmptyCurves lists curves that have no transits loaded
+func GetEmptyCurves() ([]Curve, error) {
+	rows, err := db.ReadDB.Query(`
+		SELECT id, filename, time_min, time_max,
+		       num_expected_transits, found_transits, data_type, period_days, epoch_bjd,
+		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2, COALESCE(curve_notes, '')
+		FROM Curves
+		WHERE found_transits = 0
+		ORDER BY filename
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	curves := []Curve{}
+	for rows.Next() {
+		var c Curve
+		err := rows.Scan(
+			&c.ID, &c.Filename, &c.TimeMin, &c.TimeMax,
+			&c.NumExpectedTransits, &c.FoundTransits, &c.DataType, &c.PeriodDays, &c.EpochBJD,
+			&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2, &c.Notes,
+		)
+		if err != nil {
+			return nil, err
+		}
+		curves = append(curves, c)
+	}
+	return curves, rows.Err()
+}
+
 func GetCurvesWithProgress(userID int64) ([]CurveWithProgress, error) {
 	rows, err := db.DB.Query(`
 		SELECT c.id, c.filename, c.time_min, c.time_max,
 		       c.num_expected_transits, c.found_transits, c.data_type, c.period_days, c.epoch_bjd,
-		       c.duration_days, c.planet_radius, c.semi_major_axis, c.inclination_deg, c.u1, c.u2,
+		       c.duration_days, c.planet_radius, c.semi_major_axis, c.inclination_deg, c.u1, c.u2, COALESCE(c.curve_notes, ''),
 		       COALESCE((SELECT COUNT(DISTINCT transit_index) FROM Classifications
-		                 WHERE curve_id = c.id AND user_id = ?), 0) as classified_count
+		                 WHERE curve_id = c.id AND user_id = ?), 0) as classified_count,
+		       EXISTS(SELECT 1 FROM CurveCompletions
+		              WHERE curve_id = c.id AND user_id = ?) as marked_complete
 		FROM Curves c
 		ORDER BY c.filename
-	`, userID)
+	`, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -183,8 +221,8 @@ func GetCurvesWithProgress(userID int64) ([]CurveWithProgress, error) {
 		err := rows.Scan(
 			&c.ID, &c.Filename, &c.TimeMin, &c.TimeMax,
 			&c.NumExpectedTransits, &c.FoundTransits, &c.DataType, &c.PeriodDays, &c.EpochBJD,
-			&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2,
-			&c.ClassifiedCount,
+			&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2, &c.Notes,
+			&c.ClassifiedCount, &c.MarkedComplete,
 		)
 		if err != nil {
 			return nil, err
@@ -194,17 +232,75 @@ func GetCurvesWithProgress(userID int64) ([]CurveWithProgress, error) {
 	return curves, nil
 }
 
-func GetCurveByID(id int64) (*Curve, error) {
+type PriorityCurve struct {
+	CurveWithProgress
+	// Ratings still needed to bring every transit up to minRaters raters
+	Priority int `json:"priority"`
+}
+
+// GetPriorityCurves ranks the curves userID hasn't finished by how many
+// ratings they still need, so curves with many transits and few raters
+// come first
+func GetPriorityCurves(userID int64, minRaters, limit int) ([]PriorityCurve, error) {
+	rows, err := db.ReadDB.Query(`
+		SELECT c.id, c.filename, c.time_min, c.time_max,
+		       c.num_expected_transits, c.found_transits, c.data_type, c.period_days, c.epoch_bjd,
+		       c.duration_days, c.planet_radius, c.semi_major_axis, c.inclination_deg, c.u1, c.u2, COALESCE(c.curve_notes, ''),
+		       COALESCE((SELECT COUNT(DISTINCT transit_index) FROM Classifications
+		                 WHERE curve_id = c.id AND user_id = ?), 0) AS classified_count,
+		       EXISTS(SELECT 1 FROM CurveCompletions
+		              WHERE curve_id = c.id AND user_id = ?) AS marked_complete,
+		       d.deficit
+		FROM (
+			SELECT r.curve_id, SUM(? - r.raters) AS deficit
+			FROM (
+				SELECT t.curve_id, (
+					SELECT COUNT(DISTINCT cl.user_id) FROM Classifications cl
+					WHERE cl.curve_id = t.curve_id AND cl.transit_index = t.transit_index - 1
+				) AS raters
+				FROM Transits t
+			) r
+			WHERE r.raters < ?
+			GROUP BY r.curve_id
+		) d
+		JOIN Curves c ON c.id = d.curve_id
+		WHERE classified_count < c.found_transits AND NOT marked_complete
+		ORDER BY d.deficit DESC, c.filename
+		LIMIT ?
+	`, userID, userID, minRaters, minRaters, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	curves := []PriorityCurve{}
+	for rows.Next() {
+		var c PriorityCurve
+		err := rows.Scan(
+			&c.ID, &c.Filename, &c.TimeMin, &c.TimeMax,
+			&c.NumExpectedTransits, &c.FoundTransits, &c.DataType, &c.PeriodDays, &c.EpochBJD,
+			&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2, &c.Notes,
+			&c.ClassifiedCount, &c.MarkedComplete, &c.Priority,
+		)
+		if err != nil {
+			return nil, err
+		}
+		curves = append(curves, c)
+	}
+	return curves, rows.Err()
+}
+
+func queryCurveByID(id int64) (*Curve, error) {
 	var c Curve
 	err := db.DB.QueryRow(`
 		SELECT id, filename, time_min, time_max,
 		       num_expected_transits, found_transits, data_type, period_days, epoch_bjd,
-		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2
+		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2, COALESCE(curve_notes, '')
 		FROM Curves WHERE id = ?
 	`, id).Scan(
 		&c.ID, &c.Filename, &c.TimeMin, &c.TimeMax,
 		&c.NumExpectedTransits, &c.FoundTransits, &c.DataType, &c.PeriodDays, &c.EpochBJD,
-		&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2,
+		&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2, &c.Notes,
 	)
 	if err != nil {
 		return nil, err
@@ -212,17 +308,17 @@ func GetCurveByID(id int64) (*Curve, error) {
 	return &c, nil
 }
 
-func GetCurveByFilename(filename string) (*Curve, error) {
+func queryCurveByFilename(filename string) (*Curve, error) {
 	var c Curve
 	err := db.DB.QueryRow(`
 		SELECT id, filename, time_min, time_max,
 		       num_expected_transits, found_transits, data_type, period_days, epoch_bjd,
-		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2
+		       duration_days, planet_radius, semi_major_axis, inclination_deg, u1, u2, COALESCE(curve_notes, '')
 		FROM Curves WHERE filename = ?
 	`, filename).Scan(
 		&c.ID, &c.Filename, &c.TimeMin, &c.TimeMax,
 		&c.NumExpectedTransits, &c.FoundTransits, &c.DataType, &c.PeriodDays, &c.EpochBJD,
-		&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2,
+		&c.DurationDays, &c.PlanetRadius, &c.SemiMajorAxis, &c.InclinationDeg, &c.U1, &c.U2, &c.Notes,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -232,3 +328,131 @@ func GetCurveByFilename(filename string) (*Curve, error) {
 	}
 	return &c, nil
 }
+
+func SetCurveNotes(curveID int64, notes string) error {
+	_, err := db.DB.Exec("UPDATE Curves SET curve_notes = NULLIF(?, '') WHERE id = ?", notes, curveID)
+	if err != nil {
+		return err
+	}
+	InvalidateCurveCache()
+	return nil
+}
+
+type TransitDiscrepancy struct {
+	ID                  int64  `json:"id"`
+	Filename            string `json:"filename"`
+	NumExpectedTransits int    `json:"num_expected_transits"`
+	FoundTransits       int    `json:"found_transits"`
+	Delta               int    `json:"delta"`
+}
+
+func GetTransitDiscrepancies(threshold int) ([]TransitDiscrepancy, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, filename, num_expected_transits, found_transits,
+		       found_transits - num_expected_transits AS delta
+		FROM Curves
+		WHERE num_expected_transits IS NOT NULL
+		AND ABS(found_transits - num_expected_transits) > ?
+		ORDER BY ABS(found_transits - num_expected_transits) DESC, filename
+	`, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := []TransitDiscrepancy{}
+	for rows.Next() {
+		var d TransitDiscrepancy
+		if err := rows.Scan(&d.ID, &d.Filename, &d.NumExpectedTransits, &d.FoundTransits, &d.Delta); err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	return discrepancies, rows.Err()
+}
+
+const (
+	CurveStatusNotStarted = "not_started"
+	CurveStatusInProgress = "in_progress"
+	CurveStatusCompleted  = "completed"
+)
+
+func ValidCurveStatus(status string) bool {
+	switch status {
+	case CurveStatusNotStarted, CurveStatusInProgress, CurveStatusCompleted:
+		return true
+	}
+	return false
+}
+
+type CurveProgress struct {
+	ID              int64  `json:"id"`
+	Filename        string `json:"filename"`
+	FoundTransits   int    `json:"found_transits"`
+	ClassifiedCount int    `json:"classified_count"`
+	Status          string `json:"status"`
+}
+
+// GetUserCurveProgress returns every curve with the user's progress on it,
+// optionally restricted to a single status (empty status returns all)
+func GetUserCurveProgress(userID int64, status string) ([]CurveProgress, error) {
+	rows, err := db.DB.Query(`
+		SELECT c.id, c.filename, c.found_transits,
+		       COALESCE((SELECT COUNT(DISTINCT transit_index) FROM Classifications
+		                 WHERE curve_id = c.id AND user_id = ?), 0) as classified_count
+		FROM Curves c
+		ORDER BY c.filename
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	progress := []CurveProgress{}
+	for rows.Next() {
+		var p CurveProgress
+		if err := rows.Scan(&p.ID, &p.Filename, &p.FoundTransits, &p.ClassifiedCount); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case p.FoundTransits > 0 && p.ClassifiedCount >= p.FoundTransits:
+			p.Status = CurveStatusCompleted
+		case p.ClassifiedCount > 0:
+			p.Status = CurveStatusInProgress
+		default:
+			p.Status = CurveStatusNotStarted
+		}
+
+		if status != "" && p.Status != status {
+			continue
+		}
+		progress = append(progress, p)
+	}
+	return progress, rows.Err()
+}
+
+func CompleteCurve(curveID, userID int64) error {
+	defer InvalidateUserStats(userID)
+
+	_, err := db.DB.Exec(`
+		INSERT INTO CurveCompletions (curve_id, user_id) VALUES (?, ?)
+		ON CONFLICT(curve_id, user_id) DO NOTHING
+	`, curveID, userID)
+	return err
+}
+
+// UncompleteCurve reports whether the curve had been marked complete
+func UncompleteCurve(curveID, userID int64) (bool, error) {
+	defer InvalidateUserStats(userID)
+
+	result, err := db.DB.Exec(
+		"DELETE FROM CurveCompletions WHERE curve_id = ? AND user_id = ?",
+		curveID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Curve metadata only changes on CSV import, so lookups on the classification
+// hot path are served from memory. A nil entry records a known missing curve.
+var curveCache = struct {
+	sync.RWMutex
+	byID       map[int64]*Curve
+	byFilename map[string]*Curve
+	// Bumped on invalidation so lookups that raced with an import don't store stale rows
+	generation uint64
+}{
+	byID:       map[int64]*Curve{},
+	byFilename: map[string]*Curve{},
+}
+
+// InvalidateCurveCache drops all cached curves; call it after curves change
+func InvalidateCurveCache() {
+	curveCache.Lock()
+	defer curveCache.Unlock()
+	curveCache.byID = map[int64]*Curve{}
+	curveCache.byFilename = map[string]*Curve{}
+	curveCache.generation++
+}
+
+// cacheCurve stores a lookup result under both keys, or a nil c under the
+// key that was looked up, unless the cache was invalidated after generation
+// was read
+func cacheCurve(generation uint64, c *Curve, id int64, filename string) {
+	curveCache.Lock()
+	defer curveCache.Unlock()
+	if curveCache.generation != generation {
+		return
+	}
+	if c != nil {
+		id, filename = c.ID, c.Filename
+	}
+	if id != 0 {
+		curveCache.byID[id] = c
+	}
+	if filename != "" {
+		curveCache.byFilename[filename] = c
+	}
+}
+
+func GetCurveByID(id int64) (*Curve, error) {
+	curveCache.RLock()
+	cached, ok := curveCache.byID[id]
+	generation := curveCache.generation
+	curveCache.RUnlock()
+
+	if !ok {
+		c, err := queryCurveByID(id)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		cacheCurve(generation, c, id, "")
+		cached = c
+	}
+
+	if cached == nil {
+		return nil, sql.ErrNoRows
+	}
+	c := *cached
+	return &c, nil
+}
+
+func GetCurveByFilename(filename string) (*Curve, error) {
+	curveCache.RLock()
+	cached, ok := curveCache.byFilename[filename]
+	generation := curveCache.generation
+	curveCache.RUnlock()
+
+	if !ok {
+		c, err := queryCurveByFilename(filename)
+		if err != nil {
+			return nil, err
+		}
+		cacheCurve(generation, c, 0, filename)
+		cached = c
+	}
+
+	if cached == nil {
+		return nil, nil
+	}
+	c := *cached
+	return &c, nil
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestCurveCacheInvalidation(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename, found_transits) VALUES (1, 'curveA', 3)`)
+
+	curve, err := GetCurveByFilename("curveA")
+	if err != nil || curve == nil || curve.FoundTransits != 3 {
+		t.Fatalf("got (%+v, %v)", curve, err)
+	}
+
+	// Writes behind the cache's back stay invisible until invalidation
+	dbtest.Exec(t, `UPDATE Curves SET found_transits = 5 WHERE id = 1`)
+	if curve, _ := GetCurveByID(1); curve.FoundTransits != 3 {
+		t.Errorf("before invalidation: found_transits %d, want cached 3", curve.FoundTransits)
+	}
+	InvalidateCurveCache()
+	if curve, _ := GetCurveByID(1); curve.FoundTransits != 5 {
+		t.Errorf("after invalidation: found_transits %d, want 5", curve.FoundTransits)
+	}
+
+	// Misses are cached as well
+	if curve, err := GetCurveByFilename("curveB"); curve != nil || err != nil {
+		t.Fatalf("missing curve: got (%+v, %v)", curve, err)
+	}
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (2, 'curveB')`)
+	if curve, _ := GetCurveByFilename("curveB"); curve != nil {
+		t.Errorf("before invalidation: got %+v, want cached miss", curve)
+	}
+	InvalidateCurveCache()
+	if curve, _ := GetCurveByFilename("curveB"); curve == nil || curve.ID != 2 {
+		t.Errorf("after invalidation: got %+v, want curve 2", curve)
+	}
+
+	// Callers get copies, so mutating one doesn't leak into the cache
+	curve, _ = GetCurveByID(2)
+	curve.Filename = "changed"
+	if curve, _ := GetCurveByID(2); curve.Filename != "curveB" {
+		t.Errorf("cached curve modified through a returned copy: %q", curve.Filename)
+	}
+}
+
+func TestCurveCacheDropsStaleGeneration(t *testing.T) {
+	setupTestDB(t)
+
+	curveCache.RLock()
+	generation := curveCache.generation
+	curveCache.RUnlock()
+
+	// A lookup that read its row before an import finished must not store it
+	InvalidateCurveCache()
+	cacheCurve(generation, &Curve{ID: 1, Filename: "stale"}, 1, "")
+
+	curveCache.RLock()
+	_, byID := curveCache.byID[1]
+	_, byFilename := curveCache.byFilename["stale"]
+	curveCache.RUnlock()
+	if byID || byFilename {
+		t.Error("lookup from an invalidated generation was cached")
+	}
+
+	curveCache.RLock()
+	generation = curveCache.generation
+	curveCache.RUnlock()
+	cacheCurve(generation, &Curve{ID: 1, Filename: "fresh"}, 1, "")
+	curveCache.RLock()
+	_, byFilename = curveCache.byFilename["fresh"]
+	curveCache.RUnlock()
+	if !byFilename {
+		t.Error("lookup from the current generation was not cached under its filename")
+	}
+	InvalidateCurveCache()
+}
+
+func BenchmarkGetCurveByFilename(b *testing.B) {
+	setupTestDB(b)
+	dbtest.Exec(b, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+
+	b.Run("cached", func(b *testing.B) {
+		InvalidateCurveCache()
+		for i := 0; i < b.N; i++ {
+			if _, err := GetCurveByFilename("curveA"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := queryCurveByFilename("curveA"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+)
+
+// CurveVerdicts are the allowed whole-curve triage verdicts
+var CurveVerdicts = []string{"clean", "interesting"}
+
+type CurveVerdict struct {
+	Verdict   string `json:"verdict"`
+	Notes     string `json:"notes"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func IsValidCurveVerdict(verdict string) bool {
+	for _, v := range CurveVerdicts {
+		if v == verdict {
+			return true
+		}
+	}
+	return false
+}
+
+func SaveCurveVerdict(curveID, userID int64, verdict, notes string) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO CurveVerdicts (curve_id, user_id, verdict, notes)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(curve_id, user_id) DO UPDATE SET
+			verdict = EXCLUDED.verdict,
+			notes = EXCLUDED.notes,
+			updated_at = CURRENT_TIMESTAMP
+	`, curveID, userID, verdict, notes)
+	return err
+}
+
+// GetCurveVerdict returns nil when the user has not given a verdict on the curve
+func GetCurveVerdict(curveID, userID int64) (*CurveVerdict, error) {
+	var v CurveVerdict
+	var notes, updatedAt sql.NullString
+	err := db.DB.QueryRow(`
+		SELECT verdict, notes, updated_at FROM CurveVerdicts
+		WHERE curve_id = ? AND user_id = ?
+	`, curveID, userID).Scan(&v.Verdict, &notes, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.Notes = notes.String
+	v.UpdatedAt = formatDBTimestamp(updatedAt.String)
+	return &v, nil
+}
+
+// GetCurveVerdictCounts counts a user's verdicts, with every allowed verdict present
+func GetCurveVerdictCounts(userID int64) (map[string]int, error) {
+	counts := make(map[string]int, len(CurveVerdicts))
+	for _, v := range CurveVerdicts {
+		counts[v] = 0
+	}
+
+	rows, err := db.ReadDB.Query(`
+		SELECT verdict, COUNT(*) FROM CurveVerdicts
+		WHERE user_id = ?
+		GROUP BY verdict
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var verdict string
+		var n int
+		if err := rows.Scan(&verdict, &n); err != nil {
+			return nil, err
+		}
+		counts[verdict] = n
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"emoons-web/db"
+	"strings"
+)
+
+type Disagreement struct {
+	CurveID          int64    `json:"curve_id"`
+	Filename         string   `json:"filename"`
+	TransitIndex     int      `json:"transit_index"`
+	Raters           int      `json:"raters"`
+	DisagreeingFlags []string `json:"disagreeing_flags"`
+}
+
+// disagreementQuery selects transits rated by at least minRaters users whose
+// flags are not unanimous, with MIN/MAX per flag to tell which ones split
+func disagreementQuery() string {
+	n := len(ClassificationFlags)
+	bounds := make([]string, 0, 2*n)
+	split := make([]string, n)
+	for i, flag := range ClassificationFlags {
+		bounds = append(bounds, "MIN("+flag+")", "MAX("+flag+")")
+		split[i] = "MIN(" + flag + ") <> MAX(" + flag + ")"
+	}
+	return `
+		SELECT curve_id, transit_index, COUNT(*) AS raters, ` + strings.Join(bounds, ", ") + `
+		FROM Classifications
+		GROUP BY curve_id, transit_index
+		HAVING COUNT(*) >= ? AND (` + strings.Join(split, " OR ") + `)`
+}
+
+func GetDisagreements(ctx context.Context, minRaters int) ([]Disagreement, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT d.*, c.filename
+		FROM (`+disagreementQuery()+`) d
+		JOIN Curves c ON d.curve_id = c.id
+		ORDER BY c.filename, d.transit_index
+	`, minRaters)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	n := len(ClassificationFlags)
+	bounds := make([]bool, 2*n)
+	disagreements := []Disagreement{}
+	for rows.Next() {
+		var d Disagreement
+		dest := []any{&d.CurveID, &d.TransitIndex, &d.Raters}
+		for i := range bounds {
+			dest = append(dest, &bounds[i])
+		}
+		dest = append(dest, &d.Filename)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		d.DisagreeingFlags = []string{}
+		for i, flag := range ClassificationFlags {
+			if bounds[2*i] != bounds[2*i+1] {
+				d.DisagreeingFlags = append(d.DisagreeingFlags, flag)
+			}
+		}
+		disagreements = append(disagreements, d)
+	}
+	return disagreements, rows.Err()
+}
+
+// GetDisagreementRatings returns every individual classification of the
+// transits reported by GetDisagreements, one row per rater
+func GetDisagreementRatings(ctx context.Context, minRaters int) ([]RaterClassificationExport, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT `+raterExportColumns+`
+		FROM (`+disagreementQuery()+`) d
+		JOIN Classifications ct ON ct.curve_id = d.curve_id AND ct.transit_index = d.transit_index
+		JOIN Curves c ON ct.curve_id = c.id
+		JOIN Users u ON ct.user_id = u.id`+finalExportJoin+`
+		ORDER BY c.filename, ct.transit_index, u.username
+	`, minRaters)
+	if err != nil {
+		return nil, err
+	}
+	return scanRaterExports(rows)
+}
+
+type ContentiousTransit struct {
+	CurveID      int64  `json:"curve_id"`
+	Filename     string `json:"filename"`
+	TransitIndex int    `json:"transit_index"` // 1-indexed, as in Transits
+	Raters       int    `json:"raters"`
+	Score        int    `json:"score"`
+}
+
+// GetContentiousTransits ranks transits with at least 2 raters by their
+// disagreement score, the sum over flags of the minority vote count
+func GetContentiousTransits(limit, offset int) ([]ContentiousTransit, error) {
+	minorities := make([]string, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		minorities[i] = "MIN(SUM(COALESCE(" + flag + ", 0)), COUNT(*) - SUM(COALESCE(" + flag + ", 0)))"
+	}
+
+	rows, err := db.ReadDB.Query(`
+		SELECT d.curve_id, c.filename, d.transit_index, d.raters, d.score
+		FROM (
+			SELECT curve_id, transit_index, COUNT(*) AS raters,
+				`+strings.Join(minorities, " +\n\t\t\t\t")+` AS score
+			FROM Classifications
+			GROUP BY curve_id, transit_index
+			HAVING COUNT(*) >= 2
+		) d
+		JOIN Curves c ON d.curve_id = c.id
+		WHERE d.score > 0
+		ORDER BY d.score DESC, d.raters DESC, c.filename, d.transit_index
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transits := []ContentiousTransit{}
+	for rows.Next() {
+		var t ContentiousTransit
+		var dbIndex int
+		if err := rows.Scan(&t.CurveID, &t.Filename, &dbIndex, &t.Raters, &t.Score); err != nil {
+			return nil, err
+		}
+		t.TransitIndex = ToUIIndex(dbIndex)
+		transits = append(transits, t)
+	}
+	return transits, rows.Err()
+}
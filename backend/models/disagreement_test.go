@@ -0,0 +1,31 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestGetContentiousTransits(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 3)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	// Transit 1 is split 2-1 on two flags, transit 3 on one, transit 2 is unanimous
+	dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit, left_asymmetry) VALUES
+		(1, 0, 1, 1, 0), (1, 0, 2, 1, 1), (1, 0, 3, 0, 1),
+		(1, 1, 1, 1, 0), (1, 1, 2, 1, 0),
+		(1, 2, 1, 1, 0), (1, 2, 2, 0, 0)`)
+
+	got, err := GetContentiousTransits(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ContentiousTransit{
+		{CurveID: 1, Filename: "curveA", TransitIndex: 1, Raters: 3, Score: 2},
+		{CurveID: 1, Filename: "curveA", TransitIndex: 3, Raters: 2, Score: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
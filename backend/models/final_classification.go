@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+	"strings"
+)
+
+// FinalClassification is the adjudicated answer for a transit, kept apart
+// from the individual raters' classifications
+type FinalClassification struct {
+	CurveID          int64           `json:"curve_id"`
+	TransitIndex     int             `json:"transit_index"` // 1-indexed, as in Transits
+	ReviewerID       *int64          `json:"reviewer_id"`
+	ReviewerUsername string          `json:"reviewer_username"`
+	Flags            map[string]bool `json:"flags"`
+	Notes            string          `json:"notes"`
+	UpdatedAt        string          `json:"updated_at"`
+}
+
+func SaveFinalClassification(curveID int64, transitIndex int, reviewerID int64, input ClassificationInput) error {
+	values := input.FlagValues()
+	placeholders := make([]string, len(ClassificationFlags))
+	updates := make([]string, len(ClassificationFlags))
+	args := []any{curveID, transitIndex, reviewerID}
+	for i, flag := range ClassificationFlags {
+		placeholders[i] = "?"
+		updates[i] = flag + " = EXCLUDED." + flag
+		args = append(args, values[flag])
+	}
+	args = append(args, input.Notes)
+
+	_, err := db.DB.Exec(`
+		INSERT INTO FinalClassifications (
+			curve_id, transit_index, reviewer_id, `+strings.Join(ClassificationFlags, ", ")+`, notes
+		) VALUES (?, ?, ?, `+strings.Join(placeholders, ", ")+`, ?)
+		ON CONFLICT(curve_id, transit_index) DO UPDATE SET
+			reviewer_id = EXCLUDED.reviewer_id,
+			`+strings.Join(updates, ",\n\t\t\t")+`,
+			notes = EXCLUDED.notes,
+			updated_at = CURRENT_TIMESTAMP
+	`, args...)
+	return err
+}
+
+// GetFinalClassification takes the stored 0-based transitIndex and returns nil
+// if the transit has not been adjudicated
+func GetFinalClassification(curveID int64, transitIndex int) (*FinalClassification, error) {
+	f := FinalClassification{CurveID: curveID, TransitIndex: ToUIIndex(transitIndex)}
+	var reviewerID sql.NullInt64
+	var reviewer, notes, updatedAt sql.NullString
+	flags := make([]bool, len(ClassificationFlags))
+	dest := []any{&reviewerID, &reviewer}
+	for i := range flags {
+		dest = append(dest, &flags[i])
+	}
+	dest = append(dest, &notes, &updatedAt)
+
+	err := db.DB.QueryRow(`
+		SELECT f.reviewer_id, u.username, `+prefixColumns("f.", ClassificationFlags)+`, f.notes, f.updated_at
+		FROM FinalClassifications f
+		LEFT JOIN Users u ON f.reviewer_id = u.id
+		WHERE f.curve_id = ? AND f.transit_index = ?
+	`, curveID, transitIndex).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reviewerID.Valid {
+		f.ReviewerID = &reviewerID.Int64
+	}
+	f.ReviewerUsername = reviewer.String
+	f.Flags = make(map[string]bool, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		f.Flags[flag] = flags[i]
+	}
+	f.Notes = notes.String
+	f.UpdatedAt = formatDBTimestamp(updatedAt.String)
+	return &f, nil
+}
+
+func prefixColumns(prefix string, columns []string) string {
+	prefixed := make([]string, len(columns))
+	for i, column := range columns {
+		prefixed[i] = prefix + column
+	}
+	return strings.Join(prefixed, ", ")
+}
+
+// FinalAnswer is the adjudicated answer attached to exported classifications
+type FinalAnswer struct {
+	Flags map[string]bool `json:"flags"`
+	Notes string          `json:"notes"`
+}
+
+// finalExportColumns are selected after the classification columns in
+// exports that LEFT JOIN FinalClassifications as f on ct's transit
+var finalExportColumns = "f.curve_id IS NOT NULL, " + finalFlagColumns() + ", COALESCE(f.notes, '')"
+
+func finalFlagColumns() string {
+	columns := make([]string, len(ClassificationFlags))
+	for i, flag := range ClassificationFlags {
+		columns[i] = "COALESCE(f." + flag + ", 0)"
+	}
+	return strings.Join(columns, ", ")
+}
+
+const finalExportJoin = `
+		LEFT JOIN FinalClassifications f ON f.curve_id = ct.curve_id AND f.transit_index = ct.transit_index`
+
+// finalExportScanner returns scan destinations for finalExportColumns and a
+// function that turns the scanned values into a FinalAnswer (nil if absent)
+func finalExportScanner() ([]any, func() *FinalAnswer) {
+	var present bool
+	var notes string
+	flags := make([]bool, len(ClassificationFlags))
+	dest := []any{&present}
+	for i := range flags {
+		dest = append(dest, &flags[i])
+	}
+	dest = append(dest, &notes)
+
+	return dest, func() *FinalAnswer {
+		if !present {
+			return nil
+		}
+		a := &FinalAnswer{Flags: make(map[string]bool, len(ClassificationFlags)), Notes: notes}
+		for i, flag := range ClassificationFlags {
+			a.Flags[flag] = flags[i]
+		}
+		return a
+	}
+}
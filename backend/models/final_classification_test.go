@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestGetFinalClassificationReturnsUIIndex(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 1)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+
+	dbIndex := ToDBIndex(3)
+	if err := SaveFinalClassification(1, dbIndex, 1, ClassificationInput{NormalTransit: true}); err != nil {
+		t.Fatal(err)
+	}
+	final, err := GetFinalClassification(1, dbIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final == nil {
+		t.Fatal("final classification not found")
+	}
+	if final.TransitIndex != 3 {
+		t.Errorf("TransitIndex = %d, want the 1-based 3", final.TransitIndex)
+	}
+	if !final.Flags["normal_transit"] || final.ReviewerUsername != "user1" {
+		t.Errorf("got %+v", final)
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrImportInProgress = errors.New("import already in progress")
+
+// Imports clear and rewrite whole tables, so only one may run at a time
+var importMu sync.Mutex
+
+func beginImport() error {
+	if !importMu.TryLock() {
+		return ErrImportInProgress
+	}
+	return nil
+}
+
+func LoadCurvesFromCSV(csvPath string) error {
+	if err := beginImport(); err != nil {
+		return err
+	}
+	defer importMu.Unlock()
+
+	return loadCurvesFromCSV(csvPath)
+}
+
+func LoadTransitsFromCSV(csvPath string) error {
+	if err := beginImport(); err != nil {
+		return err
+	}
+	defer importMu.Unlock()
+
+	return loadTransitsFromCSV(csvPath)
+}
+
+// ReloadFromCSV imports curves and then transits while holding the import lock
+func ReloadFromCSV(curvesCsvPath, transitsCsvPath string) error {
+	if err := beginImport(); err != nil {
+		return err
+	}
+	defer importMu.Unlock()
+
+	if err := loadCurvesFromCSV(curvesCsvPath); err != nil {
+		return err
+	}
+	return loadTransitsFromCSV(transitsCsvPath)
+}
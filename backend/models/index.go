@@ -0,0 +1,18 @@
+package models
+
+// Transits are numbered from TransitIndexBase in the UI, the CSVs and the
+// Transits table, while Classifications and the tables keyed like it
+// (ReviewFlags, FinalClassifications, TrainingExamples, ClassificationHistory)
+// store the 0-based position. Convert with these helpers instead of adding or
+// subtracting by hand; SQL joins between the two use the same offset.
+const TransitIndexBase = 1
+
+// ToDBIndex converts a UI/CSV transit index to the stored 0-based one
+func ToDBIndex(uiIndex int) int {
+	return uiIndex - TransitIndexBase
+}
+
+// ToUIIndex converts a stored 0-based transit index to the UI/CSV numbering
+func ToUIIndex(dbIndex int) int {
+	return dbIndex + TransitIndexBase
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestTransitIndexConversion(t *testing.T) {
+	tests := []struct {
+		ui, db int
+	}{
+		{TransitIndexBase, 0},
+		{TransitIndexBase + 1, 1},
+		{TransitIndexBase - 1, -1},
+		{1000, 1000 - TransitIndexBase},
+	}
+	for _, tt := range tests {
+		if got := ToDBIndex(tt.ui); got != tt.db {
+			t.Errorf("ToDBIndex(%d) = %d, want %d", tt.ui, got, tt.db)
+		}
+		if got := ToUIIndex(tt.db); got != tt.ui {
+			t.Errorf("ToUIIndex(%d) = %d, want %d", tt.db, got, tt.ui)
+		}
+	}
+
+	for ui := -2; ui <= 50; ui++ {
+		if got := ToUIIndex(ToDBIndex(ui)); got != ui {
+			t.Errorf("ToUIIndex(ToDBIndex(%d)) = %d", ui, got)
+		}
+	}
+}
+
+// The SQL joins hardcode the same offset as the helpers
+func TestTransitIndexMatchesSQLOffset(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 1)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, ?, 'curveA_2.png')`, 2)
+	dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit, notes) VALUES (1, ?, 1, 1, 'ok')`,
+		ToDBIndex(2))
+
+	notes, err := GetNotesForCurve(1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].TransitIndex != 2 {
+		t.Errorf("got notes %+v, want one on transit 2", notes)
+	}
+
+	orphans, err := FindOrphanClassifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("classification of an existing transit reported as orphan: %+v", orphans)
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// setupTestDB gives the test a fresh database and empty caches
+func setupTestDB(tb testing.TB) {
+	tb.Helper()
+	dbtest.Setup(tb)
+	InvalidateCurveCache()
+	InvalidateAllUserStats()
+}
+
+// seedUsers adds n plain users with ids 1..n
+func seedUsers(tb testing.TB, n int) {
+	tb.Helper()
+	for i := 1; i <= n; i++ {
+		dbtest.Exec(tb, `INSERT INTO Users (id, username, password_hash, fullname) VALUES (?, ?, 'x', 'Test User')`,
+			i, fmt.Sprintf("user%d", i))
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+)
+
+type ReviewFlag struct {
+	ID           int64  `json:"id"`
+	CurveID      int64  `json:"curve_id"`
+	Filename     string `json:"filename"`
+	TransitIndex int    `json:"transit_index"`
+	UserID       int64  `json:"user_id"`
+	Username     string `json:"username"`
+	Reason       string `json:"reason"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// FlagTransitForReview records (or updates the reason of) a user's escalation of a transit
+func FlagTransitForReview(curveID int64, transitIndex int, userID int64, reason string) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO ReviewFlags (curve_id, transit_index, user_id, reason)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(curve_id, transit_index, user_id) DO UPDATE SET
+			reason = EXCLUDED.reason,
+			created_at = CURRENT_TIMESTAMP
+	`, curveID, transitIndex, userID, reason)
+	return err
+}
+
+func GetReviewFlags() ([]ReviewFlag, error) {
+	rows, err := db.DB.Query(`
+		SELECT rf.id, rf.curve_id, c.filename, rf.transit_index, rf.user_id, u.username,
+		       rf.reason, rf.created_at
+		FROM ReviewFlags rf
+		JOIN Curves c ON rf.curve_id = c.id
+		JOIN Users u ON rf.user_id = u.id
+		ORDER BY rf.created_at DESC, rf.id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []ReviewFlag{}
+	for rows.Next() {
+		var f ReviewFlag
+		var createdAt sql.NullString
+		if err := rows.Scan(&f.ID, &f.CurveID, &f.Filename, &f.TransitIndex, &f.UserID, &f.Username,
+			&f.Reason, &createdAt); err != nil {
+			return nil, err
+		}
+		f.CreatedAt = formatDBTimestamp(createdAt.String)
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
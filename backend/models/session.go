@@ -0,0 +1,89 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"emoons-web/db"
+	"encoding/hex"
+	"time"
+)
+
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+func CreateSession(userID int64) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	_, err := db.DB.Exec("INSERT INTO Sessions (id, user_id) VALUES (?, ?)", id, userID)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetSession returns the session with id, or nil if it doesn't exist or
+// belongs to a deactivated user
+func GetSession(id string) (*Session, error) {
+	var s Session
+	err := db.DB.QueryRow(`
+		SELECT s.id, s.user_id, s.created_at, s.last_seen
+		FROM Sessions s
+		JOIN Users u ON s.user_id = u.id
+		WHERE s.id = ? AND u.active = 1
+	`, id).Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.LastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func TouchSession(id string) error {
+	_, err := db.DB.Exec("UPDATE Sessions SET last_seen = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+func DeleteSession(id string) error {
+	_, err := db.DB.Exec("DELETE FROM Sessions WHERE id = ?", id)
+	return err
+}
+
+// DeleteOtherSessions removes every session of the user except keepID
+func DeleteOtherSessions(userID int64, keepID string) error {
+	_, err := db.DB.Exec("DELETE FROM Sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	return err
+}
+
+func DeleteUserSessions(userID int64) error {
+	_, err := db.DB.Exec("DELETE FROM Sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions created more than maxAge ago, whose
+// tokens have expired, and those idle for longer than idleTimeout unless it
+// is zero. It returns how many were removed.
+func DeleteExpiredSessions(maxAge, idleTimeout time.Duration) (int64, error) {
+	idleSeconds := int64(-1)
+	if idleTimeout > 0 {
+		idleSeconds = int64(idleTimeout.Seconds())
+	}
+	result, err := db.DB.Exec(`
+		DELETE FROM Sessions
+		WHERE created_at < datetime('now', '-' || ? || ' seconds')
+		   OR (? >= 0 AND last_seen < datetime('now', '-' || ? || ' seconds'))
+	`, int64(maxAge.Seconds()), idleSeconds, idleSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"emoons-web/db"
+	"emoons-web/db/dbtest"
+)
+
+func sessionIDs(t *testing.T) map[string]bool {
+	t.Helper()
+	rows, err := db.DB.Query(`SELECT id FROM Sessions`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 1)
+	dbtest.Exec(t, `INSERT INTO Sessions (id, user_id, created_at, last_seen) VALUES
+		('fresh', 1, datetime('now', '-1 hours'), datetime('now')),
+		('idle', 1, datetime('now', '-3 hours'), datetime('now', '-2 hours')),
+		('expired', 1, datetime('now', '-25 hours'), datetime('now'))`)
+
+	// Without an idle timeout only token expiry counts
+	n, err := DeleteExpiredSessions(24*time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := sessionIDs(t); n != 1 || len(ids) != 2 || !ids["fresh"] || !ids["idle"] {
+		t.Errorf("no idle timeout: deleted %d, left %v", n, ids)
+	}
+
+	n, err = DeleteExpiredSessions(24*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := sessionIDs(t); n != 1 || len(ids) != 1 || !ids["fresh"] {
+		t.Errorf("1h idle timeout: deleted %d, left %v", n, ids)
+	}
+}
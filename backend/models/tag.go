@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+	"strings"
+)
+
+type TaggedTransit struct {
+	ClassificationID int64    `json:"classification_id"`
+	CurveID          int64    `json:"curve_id"`
+	Filename         string   `json:"filename"`
+	TransitIndex     int      `json:"transit_index"` // 1-indexed, as in Transits
+	Tags             []string `json:"tags"`
+}
+
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+func setClassificationTags(tx *sql.Tx, classificationID int64, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM ClassificationTags WHERE classification_id = ?", classificationID); err != nil {
+		return err
+	}
+	for _, tag := range NormalizeTags(tags) {
+		_, err := tx.Exec(
+			"INSERT INTO ClassificationTags (classification_id, tag) VALUES (?, ?)",
+			classificationID, tag,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetClassificationTags(classificationID int64) ([]string, error) {
+	rows, err := db.DB.Query(
+		"SELECT tag FROM ClassificationTags WHERE classification_id = ? ORDER BY tag",
+		classificationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// GetTransitsByTag lists the user's classifications carrying tag, each with
+// all of its tags. Tags are fetched one per row, since a tag may contain
+// any character a joined string could be split on.
+func GetTransitsByTag(userID int64, tag string) ([]TaggedTransit, error) {
+	rows, err := db.DB.Query(`
+		SELECT ct.id, ct.curve_id, c.filename, ct.transit_index, a.tag
+		FROM Classifications ct
+		JOIN Curves c ON ct.curve_id = c.id
+		JOIN ClassificationTags t ON t.classification_id = ct.id
+		JOIN ClassificationTags a ON a.classification_id = ct.id
+		WHERE ct.user_id = ? AND t.tag = ?
+		ORDER BY c.filename, ct.transit_index, ct.id, a.tag
+	`, userID, strings.ToLower(strings.TrimSpace(tag)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transits := []TaggedTransit{}
+	for rows.Next() {
+		var t TaggedTransit
+		var dbIndex int
+		var tag string
+		if err := rows.Scan(&t.ClassificationID, &t.CurveID, &t.Filename, &dbIndex, &tag); err != nil {
+			return nil, err
+		}
+		if n := len(transits); n > 0 && transits[n-1].ClassificationID == t.ClassificationID {
+			transits[n-1].Tags = append(transits[n-1].Tags, tag)
+			continue
+		}
+		t.TransitIndex = ToUIIndex(dbIndex)
+		t.Tags = []string{tag}
+		transits = append(transits, t)
+	}
+	return transits, rows.Err()
+}
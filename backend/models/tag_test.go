@@ -0,0 +1,30 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestGetTransitsByTag(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 2)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Classifications (id, curve_id, transit_index, user_id, normal_transit) VALUES
+		(1, 1, 0, 1, 1), (2, 1, 2, 1, 1), (3, 1, 0, 2, 1)`)
+	dbtest.Exec(t, `INSERT INTO ClassificationTags (classification_id, tag) VALUES
+		(1, 'noisy'), (2, 'noisy'), (2, 'blend'), (2, 'ingress, early'), (3, 'noisy')`)
+
+	got, err := GetTransitsByTag(1, " Noisy ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TaggedTransit{
+		{ClassificationID: 1, CurveID: 1, Filename: "curveA", TransitIndex: 1, Tags: []string{"noisy"}},
+		{ClassificationID: 2, CurveID: 1, Filename: "curveA", TransitIndex: 3, Tags: []string{"blend", "ingress, early", "noisy"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
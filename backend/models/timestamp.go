@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Layouts SQLite may hand back for DATETIME text. CURRENT_TIMESTAMP is UTC
+// but carries no zone, so zoneless values are read as UTC.
+var dbTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// formatDBTimestamp renders a SQLite timestamp as RFC3339 UTC, e.g.
+// "2024-01-02T03:04:05Z". Empty or unrecognized values are returned as is.
+func formatDBTimestamp(s string) string {
+	for _, layout := range dbTimestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func TestFormatDBTimestamp(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"2024-01-02 03:04:05", "2024-01-02T03:04:05Z"},
+		{"2024-01-02T03:04:05", "2024-01-02T03:04:05Z"},
+		{"2024-01-02 03:04:05.123", "2024-01-02T03:04:05Z"},
+		{"2024-01-02 05:04:05+02:00", "2024-01-02T03:04:05Z"},
+		{"2024-01-02T03:04:05Z", "2024-01-02T03:04:05Z"},
+		{"", ""},
+		{"not a time", "not a time"},
+	}
+	for _, tt := range tests {
+		if got := formatDBTimestamp(tt.in); got != tt.want {
+			t.Errorf("formatDBTimestamp(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Timestamps written by CURRENT_TIMESTAMP come back with an explicit UTC zone
+// everywhere they are returned
+func TestTimestampsIncludeZone(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 1)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	if err := SaveClassification(1, 0, 1, ClassificationInput{NormalTransit: true}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	classification, err := GetClassification(1, 0, 1)
+	if err != nil || classification == nil {
+		t.Fatalf("got (%v, %v)", classification, err)
+	}
+	encoded, err := json.Marshal(classification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(decoded.Timestamp, "Z") {
+		t.Errorf("classification timestamp %q has no zone", decoded.Timestamp)
+	}
+
+	exports, err := GetUserClassificationsForExport(context.Background(), 1, ExportFilter{})
+	if err != nil || len(exports) != 1 {
+		t.Fatalf("export: got (%v, %v)", exports, err)
+	}
+	if !strings.HasSuffix(exports[0].Timestamp, "Z") {
+		t.Errorf("export timestamp %q has no zone", exports[0].Timestamp)
+	}
+
+	users, err := ListUsers()
+	if err != nil || len(users) != 1 {
+		t.Fatalf("users: got (%v, %v)", users, err)
+	}
+	if !strings.HasSuffix(users[0].LastActivity, "Z") {
+		t.Errorf("last activity %q has no zone", users[0].LastActivity)
+	}
+}
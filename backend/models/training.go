@@ -0,0 +1,140 @@
+package models
+
+import (
+	"database/sql"
+	"emoons-web/db"
+	"strings"
+)
+
+type TrainingExample struct {
+	ID            int64    `json:"id"`
+	CurveID       int64    `json:"curve_id"`
+	Filename      string   `json:"filename"`
+	TransitIndex  int      `json:"transit_index"` // 1-indexed, as in Transits
+	ExpectedFlags []string `json:"expected_flags"`
+	CreatedAt     string   `json:"created_at"`
+}
+
+type TrainingMiss struct {
+	Flag     string `json:"flag"`
+	Expected bool   `json:"expected"`
+	Answered bool   `json:"answered"`
+}
+
+type TrainingScore struct {
+	ExampleID int64          `json:"example_id"`
+	Correct   int            `json:"correct"`
+	Total     int            `json:"total"`
+	Score     float64        `json:"score"`
+	Wrong     []TrainingMiss `json:"wrong"`
+}
+
+const trainingExampleColumns = `
+	te.id, te.curve_id, c.filename, te.transit_index + 1, te.expected_flags, te.created_at`
+
+func scanTrainingExample(row rowScanner, e *TrainingExample) error {
+	var flags string
+	var createdAt sql.NullString
+	if err := row.Scan(&e.ID, &e.CurveID, &e.Filename, &e.TransitIndex, &flags, &createdAt); err != nil {
+		return err
+	}
+	e.ExpectedFlags = []string{}
+	if flags != "" {
+		e.ExpectedFlags = strings.Split(flags, ",")
+	}
+	e.CreatedAt = formatDBTimestamp(createdAt.String)
+	return nil
+}
+
+// SaveTrainingExample adds a training transit or replaces its expected
+// flags. flags must already be resolved through ResolveFlag.
+func SaveTrainingExample(curveID int64, transitIndex int, flags []string, createdBy int64) (int64, error) {
+	var id int64
+	err := db.DB.QueryRow(`
+		INSERT INTO TrainingExamples (curve_id, transit_index, expected_flags, created_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(curve_id, transit_index) DO UPDATE SET
+			expected_flags = EXCLUDED.expected_flags,
+			created_by = EXCLUDED.created_by,
+			created_at = CURRENT_TIMESTAMP
+		RETURNING id
+	`, curveID, transitIndex, strings.Join(flags, ","), createdBy).Scan(&id)
+	return id, err
+}
+
+func DeleteTrainingExample(id int64) (bool, error) {
+	result, err := db.DB.Exec("DELETE FROM TrainingExamples WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func ListTrainingExamples() ([]TrainingExample, error) {
+	rows, err := db.DB.Query(`
+		SELECT ` + trainingExampleColumns + `
+		FROM TrainingExamples te
+		JOIN Curves c ON te.curve_id = c.id
+		ORDER BY c.filename, te.transit_index
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	examples := []TrainingExample{}
+	for rows.Next() {
+		var e TrainingExample
+		if err := scanTrainingExample(rows, &e); err != nil {
+			return nil, err
+		}
+		examples = append(examples, e)
+	}
+	return examples, rows.Err()
+}
+
+// GetTrainingExample returns nil if there is no example with that id
+func GetTrainingExample(id int64) (*TrainingExample, error) {
+	return queryTrainingExample("WHERE te.id = ?", id)
+}
+
+// GetRandomTrainingExample returns nil when no examples are defined
+func GetRandomTrainingExample() (*TrainingExample, error) {
+	return queryTrainingExample("ORDER BY RANDOM() LIMIT 1")
+}
+
+func queryTrainingExample(clause string, args ...any) (*TrainingExample, error) {
+	var e TrainingExample
+	err := scanTrainingExample(db.DB.QueryRow(`
+		SELECT `+trainingExampleColumns+`
+		FROM TrainingExamples te
+		JOIN Curves c ON te.curve_id = c.id
+		`+clause, args...), &e)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Score compares an answer with the expected flags, one point per flag
+func (e *TrainingExample) Score(answer map[string]bool) TrainingScore {
+	expected := make(map[string]bool, len(e.ExpectedFlags))
+	for _, flag := range e.ExpectedFlags {
+		expected[flag] = true
+	}
+
+	score := TrainingScore{ExampleID: e.ID, Total: len(ClassificationFlags), Wrong: []TrainingMiss{}}
+	for _, flag := range ClassificationFlags {
+		if answer[flag] == expected[flag] {
+			score.Correct++
+			continue
+		}
+		score.Wrong = append(score.Wrong, TrainingMiss{Flag: flag, Expected: expected[flag], Answered: answer[flag]})
+	}
+	score.Score = float64(score.Correct) / float64(score.Total)
+	return score
+}
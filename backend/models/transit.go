@@ -1,15 +1,23 @@
 package models
 
 import (
-	"encoding/csv"
+	"database/sql"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 
 	"emoons-web/db"
 )
 
+// Physical parameters missing on the transit (NULL) fall back to the parent
+// curve's values; a stored zero is kept as is
+const transitParamColumns = `COALESCE(t.period, c.period_days), t.duration, COALESCE(t.inc, c.inclination_deg),
+			COALESCE(t.u1, c.u1), COALESCE(t.u2, c.u2)`
+
 type Transit struct {
 	ID           int64    `json:"id"`
 	CurveID      int64    `json:"curve_id"`
@@ -21,30 +29,179 @@ type Transit struct {
 	RpFitted     float64  `json:"rp_fitted"`
 	AFitted      float64  `json:"a_fitted"`
 	RMSResiduals *float64 `json:"rms_residuals"`
-	Period       float64  `json:"period"`
+	Period       *float64 `json:"period"`
 	Duration     *float64 `json:"duration"`
-	Inc          float64  `json:"inc"`
-	U1           float64  `json:"u1"`
-	U2           float64  `json:"u2"`
+	Inc          *float64 `json:"inc"`
+	U1           *float64 `json:"u1"`
+	U2           *float64 `json:"u2"`
 	PlotFile     string   `json:"plot_file"`
 }
 
-func LoadTransitsFromCSV(csvPath string) error {
+// Rows inserted per transaction when importing transits
+const transitImportBatchSize = 1000
+
+// Rows per multi-row INSERT when importing transits; 1 inserts them one at a time
+var TransitInsertBatchSize = 100
+
+const transitInsertColumns = 14
+
+// SQLite allows at most 32766 bound parameters per statement
+const maxTransitInsertBatch = 32766 / transitInsertColumns
+
+type ValueRange struct {
+	Min float64
+	Max float64
+}
+
+func (r ValueRange) Contains(v float64) bool {
+	return v >= r.Min && v <= r.Max
+}
+
+// Plausible values for fitted transit parameters; rows outside them are skipped on import
+type TransitBounds struct {
+	Inclination ValueRange // degrees
+	Period      ValueRange // days
+	U1          ValueRange // quadratic limb darkening
+	U2          ValueRange
+}
+
+var TransitImportBounds = TransitBounds{
+	Inclination: ValueRange{Min: 0, Max: 90},
+	Period:      ValueRange{Min: 1e-6, Max: 1e5},
+	U1:          ValueRange{Min: 0, Max: 2},
+	U2:          ValueRange{Min: -1, Max: 1},
+}
+
+// validate returns a description of the first out-of-range value, or "" if all are plausible
+func (b TransitBounds) validate(r transitRecord) string {
+	checks := []struct {
+		name  string
+		value *float64
+		r     ValueRange
+	}{
+		{"inc", r.inc, b.Inclination},
+		{"period", r.period, b.Period},
+		{"u1", r.u1, b.U1},
+		{"u2", r.u2, b.U2},
+	}
+	for _, check := range checks {
+		// Missing values fall back to the curve and are not checked here
+		if check.value != nil && !check.r.Contains(*check.value) {
+			return fmt.Sprintf("%s=%g outside [%g, %g]", check.name, *check.value, check.r.Min, check.r.Max)
+		}
+	}
+	return ""
+}
+
+type transitRecord struct {
+	filename     string
+	transitIndex int
+	t0Expected   float64
+	t0Fitted     *float64
+	ttvMinutes   *float64
+	rpFitted     float64
+	aFitted      float64
+	rmsResiduals *float64
+	period       *float64
+	duration     *float64
+	inc          *float64
+	u1           *float64
+	u2           *float64
+	plotFile     string
+}
+
+func parseTransitRecord(record []string) transitRecord {
+	r := transitRecord{filename: record[0], plotFile: record[13]}
+
+	if idx, err := strconv.Atoi(record[1]); err == nil {
+		r.transitIndex = idx
+	}
+	if v, err := strconv.ParseFloat(record[2], 64); err == nil {
+		r.t0Expected = v
+	}
+	if v, err := strconv.ParseFloat(record[3], 64); err == nil && record[3] != "" {
+		r.t0Fitted = &v
+	}
+	if v, err := strconv.ParseFloat(record[4], 64); err == nil && record[4] != "" {
+		r.ttvMinutes = &v
+	}
+	if v, err := strconv.ParseFloat(record[5], 64); err == nil {
+		r.rpFitted = v
+	}
+	if v, err := strconv.ParseFloat(record[6], 64); err == nil {
+		r.aFitted = v
+	}
+	if v, err := strconv.ParseFloat(record[7], 64); err == nil && record[7] != "" {
+		r.rmsResiduals = &v
+	}
+	if v, err := strconv.ParseFloat(record[8], 64); err == nil && record[8] != "" {
+		r.period = &v
+	}
+	if v, err := strconv.ParseFloat(record[9], 64); err == nil && record[9] != "" {
+		r.duration = &v
+	}
+	if v, err := strconv.ParseFloat(record[10], 64); err == nil && record[10] != "" {
+		r.inc = &v
+	}
+	if v, err := strconv.ParseFloat(record[11], 64); err == nil && record[11] != "" {
+		r.u1 = &v
+	}
+	if v, err := strconv.ParseFloat(record[12], 64); err == nil && record[12] != "" {
+		r.u2 = &v
+	}
+	return r
+}
+
+type pendingTransit struct {
+	transitRecord
+	curveID int64
+}
+
+func (p pendingTransit) args() []any {
+	return []any{p.curveID, p.transitIndex, p.t0Expected, p.t0Fitted, p.ttvMinutes,
+		p.rpFitted, p.aFitted, p.rmsResiduals, p.period, p.duration, p.inc, p.u1, p.u2, p.plotFile}
+}
+
+// transitInsertSQL builds an INSERT with rows value tuples
+func transitInsertSQL(rows int) string {
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", transitInsertColumns), ", ") + ")"
+	values := make([]string, rows)
+	for i := range values {
+		values[i] = tuple
+	}
+	return `INSERT INTO Transits (curve_id, transit_index, t0_expected, t0_fitted, ttv_minutes,
+		rp_fitted, a_fitted, rms_residuals, period, duration, inc, u1, u2, plot_file)
+		VALUES ` + strings.Join(values, ", ")
+}
+
+func loadTransitsFromCSV(csvPath string) error {
+	// Transit imports rewrite curves.found_transits
+	defer InvalidateCurveCache()
+	defer InvalidateAllUserStats()
+
 	file, err := os.Open(csvPath)
 	if err != nil {
 		return fmt.Errorf("failed to open CSV: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
+	// Stream rows instead of ReadAll so memory stays bounded on large files.
+	// Short rows are skipped below rather than aborting the whole import.
+	reader := CSVImportFormat.newReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	// Skip header row, and make sure there is data before clearing anything
+	if _, err := reader.Read(); err != nil && err != io.EOF {
 		return fmt.Errorf("failed to read CSV: %w", err)
 	}
-
-	if len(records) < 2 {
+	record, err := reader.Read()
+	if err == io.EOF {
 		return fmt.Errorf("CSV has no data rows")
 	}
+	if err != nil {
+		return fmt.Errorf("failed to read CSV: %w", err)
+	}
 
 	// Clear existing transits
 	_, err = db.DB.Exec("DELETE FROM Transits")
@@ -75,99 +232,179 @@ func LoadTransitsFromCSV(csvPath string) error {
 	}
 	rows.Close()
 
-	// Count transits per curve
+	// Transits inserted per curve, for the summary log
 	transitCounts := make(map[int64]int)
 	inserted := 0
+	outOfRange := 0
 
-	// Skip header row
-	for _, record := range records[1:] {
-		if len(record) < 14 {
-			continue
-		}
-
-		filename := record[0]
-		curveID, ok := curveMap[filename]
-		if !ok {
-			log.Printf("Warning: no curve found for file %s", filename)
-			continue
+	// Prepare once and bind to each transaction with tx.Stmt; the single-row
+	// insert retries batches that fail as a whole
+	batchSize := TransitInsertBatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if batchSize > maxTransitInsertBatch {
+		batchSize = maxTransitInsertBatch
+	}
+	rowStmt, err := db.DB.Prepare(transitInsertSQL(1))
+	if err != nil {
+		return fmt.Errorf("failed to prepare transit insert: %w", err)
+	}
+	defer rowStmt.Close()
+	batchStmt := rowStmt
+	if batchSize > 1 {
+		batchStmt, err = db.DB.Prepare(transitInsertSQL(batchSize))
+		if err != nil {
+			return fmt.Errorf("failed to prepare transit insert: %w", err)
 		}
+		defer batchStmt.Close()
+	}
 
-		var transitIndex int
-		var t0Expected, rpFitted, aFitted, period, inc, u1, u2 float64
-		var t0Fitted, ttvMinutes, rmsResiduals, duration *float64
-
-		if idx, err := strconv.Atoi(record[1]); err == nil {
-			transitIndex = idx
+	var tx *sql.Tx
+	pending := 0
+	commit := func() error {
+		if tx == nil {
+			return nil
 		}
-		if v, err := strconv.ParseFloat(record[2], 64); err == nil {
-			t0Expected = v
+		err := tx.Commit()
+		tx, pending = nil, 0
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
 		}
-		if v, err := strconv.ParseFloat(record[3], 64); err == nil && record[3] != "" {
-			t0Fitted = &v
+	}()
+
+	batch := make([]pendingTransit, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
-		if v, err := strconv.ParseFloat(record[4], 64); err == nil && record[4] != "" {
-			ttvMinutes = &v
+		if tx == nil {
+			var err error
+			tx, err = db.DB.Begin()
+			if err != nil {
+				return fmt.Errorf("failed to begin transaction: %w", err)
+			}
 		}
-		if v, err := strconv.ParseFloat(record[5], 64); err == nil {
-			rpFitted = v
+
+		added := batch
+		args := make([]any, 0, len(batch)*transitInsertColumns)
+		for _, p := range batch {
+			args = append(args, p.args()...)
 		}
-		if v, err := strconv.ParseFloat(record[6], 64); err == nil {
-			aFitted = v
+		var err error
+		if len(batch) == batchSize {
+			_, err = tx.Stmt(batchStmt).Exec(args...)
+		} else {
+			_, err = tx.Exec(transitInsertSQL(len(batch)), args...)
 		}
-		if v, err := strconv.ParseFloat(record[7], 64); err == nil && record[7] != "" {
-			rmsResiduals = &v
+		if err != nil {
+			// Insert row by row so one bad row only skips itself
+			added = added[:0:0]
+			stmt := tx.Stmt(rowStmt)
+			for _, p := range batch {
+				if _, err := stmt.Exec(p.args()...); err != nil {
+					log.Printf("Warning: failed to insert transit %s:%d: %v", p.filename, p.transitIndex, err)
+					continue
+				}
+				added = append(added, p)
+			}
 		}
-		if v, err := strconv.ParseFloat(record[8], 64); err == nil {
-			period = v
+		for _, p := range added {
+			transitCounts[p.curveID]++
 		}
-		if v, err := strconv.ParseFloat(record[9], 64); err == nil && record[9] != "" {
-			duration = &v
+		inserted += len(added)
+		pending += len(added)
+		batch = batch[:0]
+
+		if pending >= transitImportBatchSize {
+			if err := commit(); err != nil {
+				return fmt.Errorf("failed to commit transits: %w", err)
+			}
+			log.Printf("Imported %d transits so far", inserted)
 		}
-		if v, err := strconv.ParseFloat(record[10], 64); err == nil {
-			inc = v
+		return nil
+	}
+
+	for ; err != io.EOF; record, err = reader.Read() {
+		if err != nil {
+			return fmt.Errorf("failed to read CSV: %w", err)
 		}
-		if v, err := strconv.ParseFloat(record[11], 64); err == nil {
-			u1 = v
+		if len(record) < 14 {
+			continue
 		}
-		if v, err := strconv.ParseFloat(record[12], 64); err == nil {
-			u2 = v
+
+		// Validate before batching so rejected rows never reach an insert
+		r := parseTransitRecord(record)
+		curveID, ok := curveMap[r.filename]
+		if !ok {
+			log.Printf("Warning: no curve found for file %s", r.filename)
+			continue
 		}
-		plotFile := record[13]
 
-		_, err = db.DB.Exec(`
-			INSERT INTO Transits (curve_id, transit_index, t0_expected, t0_fitted, ttv_minutes,
-				rp_fitted, a_fitted, rms_residuals, period, duration, inc, u1, u2, plot_file)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, curveID, transitIndex, t0Expected, t0Fitted, ttvMinutes,
-			rpFitted, aFitted, rmsResiduals, period, duration, inc, u1, u2, plotFile)
-		if err != nil {
-			log.Printf("Warning: failed to insert transit %s:%d: %v", filename, transitIndex, err)
+		if problem := TransitImportBounds.validate(r); problem != "" {
+			log.Printf("Warning: skipping transit %s:%d: %s", r.filename, r.transitIndex, problem)
+			outOfRange++
 			continue
 		}
 
-		transitCounts[curveID]++
-		inserted++
+		batch = append(batch, pendingTransit{transitRecord: r, curveID: curveID})
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := commit(); err != nil {
+		return fmt.Errorf("failed to commit transits: %w", err)
 	}
 
-	// Update found_transits for each curve
-	for curveID, count := range transitCounts {
-		_, err = db.DB.Exec("UPDATE Curves SET found_transits = ? WHERE id = ?", count, curveID)
-		if err != nil {
-			log.Printf("Warning: failed to update found_transits for curve %d: %v", curveID, err)
-		}
+	if err := updateFoundTransits(); err != nil {
+		return err
 	}
 
-	log.Printf("Loaded %d transits into database for %d curves", inserted, len(transitCounts))
+	log.Printf("Loaded %d transits into database for %d curves (%d skipped with out-of-range values)",
+		inserted, len(transitCounts), outOfRange)
 	return nil
 }
 
-func GetTransitsForFile(filename string) []Transit {
+// updateFoundTransits recounts every curve's transits in a single statement,
+// so curves without transits end up at zero too
+func updateFoundTransits() error {
+	_, err := db.DB.Exec(`
+		UPDATE Curves SET found_transits = (
+			SELECT COUNT(*) FROM Transits WHERE curve_id = Curves.id
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to update found_transits: %w", err)
+	}
+	return nil
+}
+
+// GetTransitsForFile lists a curve's transits; a non-nil fitted keeps only
+// transits whose model fit succeeded (true) or failed (false)
+func GetTransitsForFile(filename string, fitted *bool) []Transit {
+	where := "c.filename = ?"
+	if fitted != nil {
+		if *fitted {
+			where += " AND t.t0_fitted IS NOT NULL"
+		} else {
+			where += " AND t.t0_fitted IS NULL"
+		}
+	}
+
 	rows, err := db.DB.Query(`
 		SELECT t.id, t.curve_id, t.transit_index, t.t0_expected, t.t0_fitted, t.ttv_minutes,
-			t.rp_fitted, t.a_fitted, t.rms_residuals, t.period, t.duration, t.inc, t.u1, t.u2, t.plot_file
+			t.rp_fitted, t.a_fitted, t.rms_residuals, `+transitParamColumns+`, t.plot_file
 		FROM Transits t
 		JOIN Curves c ON t.curve_id = c.id
-		WHERE c.filename = ?
+		WHERE `+where+`
 		ORDER BY t.transit_index
 	`, filename)
 	if err != nil {
@@ -192,7 +429,7 @@ func GetTransitsForFile(filename string) []Transit {
 func GetTransitsByCurveID(curveID int64) []Transit {
 	rows, err := db.DB.Query(`
 		SELECT t.id, t.curve_id, c.filename, t.transit_index, t.t0_expected, t.t0_fitted, t.ttv_minutes,
-			t.rp_fitted, t.a_fitted, t.rms_residuals, t.period, t.duration, t.inc, t.u1, t.u2, t.plot_file
+			t.rp_fitted, t.a_fitted, t.rms_residuals, `+transitParamColumns+`, t.plot_file
 		FROM Transits t
 		JOIN Curves c ON t.curve_id = c.id
 		WHERE t.curve_id = ?
@@ -221,7 +458,7 @@ func GetTransit(filename string, index int) *Transit {
 	t.File = filename
 	err := db.DB.QueryRow(`
 		SELECT t.id, t.curve_id, t.transit_index, t.t0_expected, t.t0_fitted, t.ttv_minutes,
-			t.rp_fitted, t.a_fitted, t.rms_residuals, t.period, t.duration, t.inc, t.u1, t.u2, t.plot_file
+			t.rp_fitted, t.a_fitted, t.rms_residuals, `+transitParamColumns+`, t.plot_file
 		FROM Transits t
 		JOIN Curves c ON t.curve_id = c.id
 		WHERE c.filename = ? AND t.transit_index = ?
@@ -233,6 +470,161 @@ func GetTransit(filename string, index int) *Transit {
 	return &t
 }
 
+func GetTransitByID(id int64) (*Transit, error) {
+	var t Transit
+	err := db.DB.QueryRow(`
+		SELECT t.id, t.curve_id, c.filename, t.transit_index, t.t0_expected, t.t0_fitted, t.ttv_minutes,
+			t.rp_fitted, t.a_fitted, t.rms_residuals, `+transitParamColumns+`, t.plot_file
+		FROM Transits t
+		JOIN Curves c ON t.curve_id = c.id
+		WHERE t.id = ?
+	`, id).Scan(&t.ID, &t.CurveID, &t.File, &t.TransitIndex, &t.T0Expected, &t.T0Fitted, &t.TTVMinutes,
+		&t.RpFitted, &t.AFitted, &t.RMSResiduals, &t.Period, &t.Duration, &t.Inc, &t.U1, &t.U2, &t.PlotFile)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTimeNeighbors returns the transits of a curve just before and after the
+// one at (t0, transitIndex), ordered by t0_expected and then transit_index so
+// transits sharing a t0 are still visited one by one. Either is nil at the
+// end of the curve.
+func GetTimeNeighbors(curveID int64, t0 float64, transitIndex int) (prev, next *Transit, err error) {
+	neighbor := func(cond, order string) (*Transit, error) {
+		var t Transit
+		err := db.DB.QueryRow(`
+			SELECT t.id, t.curve_id, c.filename, t.transit_index, t.t0_expected, t.t0_fitted, t.ttv_minutes,
+				t.rp_fitted, t.a_fitted, t.rms_residuals, `+transitParamColumns+`, t.plot_file
+			FROM Transits t
+			JOIN Curves c ON t.curve_id = c.id
+			WHERE t.curve_id = ? AND (t.t0_expected, t.transit_index) `+cond+` (?, ?)
+			ORDER BY t.t0_expected `+order+`, t.transit_index `+order+`
+			LIMIT 1
+		`, curveID, t0, transitIndex).Scan(&t.ID, &t.CurveID, &t.File, &t.TransitIndex, &t.T0Expected, &t.T0Fitted, &t.TTVMinutes,
+			&t.RpFitted, &t.AFitted, &t.RMSResiduals, &t.Period, &t.Duration, &t.Inc, &t.U1, &t.U2, &t.PlotFile)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+
+	if prev, err = neighbor("<", "DESC"); err != nil {
+		return nil, nil, err
+	}
+	if next, err = neighbor(">", "ASC"); err != nil {
+		return nil, nil, err
+	}
+	return prev, next, nil
+}
+
+// TransitModelParams fields are nil when neither the transit nor the curve
+// has a value
+type TransitModelParams struct {
+	T0     *float64 `json:"t0"`
+	Period *float64 `json:"period"`
+	Rp     *float64 `json:"rp"`
+	A      *float64 `json:"a"`
+	Inc    *float64 `json:"inc"`
+	U1     *float64 `json:"u1"`
+	U2     *float64 `json:"u2"`
+	// "transit", "curve", or "" when neither has limb-darkening coefficients
+	LimbDarkeningSource string `json:"limb_darkening_source"`
+}
+
+// GetTransitModelParams returns the parameters needed to reconstruct the fitted
+// light-curve model, taking limb darkening from the curve when the transit has none
+func GetTransitModelParams(filename string, index int) (*TransitModelParams, error) {
+	var p TransitModelParams
+	var tU1, tU2, cU1, cU2 sql.NullFloat64
+	err := db.DB.QueryRow(`
+		SELECT COALESCE(t.t0_fitted, t.t0_expected), COALESCE(t.period, c.period_days),
+			t.rp_fitted, t.a_fitted, COALESCE(t.inc, c.inclination_deg),
+			t.u1, t.u2, c.u1, c.u2
+		FROM Transits t
+		JOIN Curves c ON t.curve_id = c.id
+		WHERE c.filename = ? AND t.transit_index = ?
+	`, filename, index).Scan(&p.T0, &p.Period, &p.Rp, &p.A, &p.Inc, &tU1, &tU2, &cU1, &cU2)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case tU1.Valid && tU2.Valid:
+		p.U1, p.U2 = &tU1.Float64, &tU2.Float64
+		p.LimbDarkeningSource = "transit"
+	case cU1.Valid && cU2.Valid:
+		p.U1, p.U2 = &cU1.Float64, &cU2.Float64
+		p.LimbDarkeningSource = "curve"
+	}
+	return &p, nil
+}
+
+// GetAllPlotFiles returns distinct plot file names in order, starting after the given one
+func GetAllPlotFiles(after string, limit int) ([]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT DISTINCT plot_file FROM Transits
+		WHERE plot_file IS NOT NULL AND plot_file != '' AND plot_file > ?
+		ORDER BY plot_file
+		LIMIT ?
+	`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []string{}
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+type ClassifiedPlot struct {
+	Filename     string
+	TransitIndex int // 1-indexed, as in Transits
+	PlotFile     string
+}
+
+// GetClassifiedPlots lists the plot of every transit the user classified
+func GetClassifiedPlots(userID int64) ([]ClassifiedPlot, error) {
+	rows, err := db.DB.Query(`
+		SELECT c.filename, t.transit_index, t.plot_file
+		FROM Classifications cl
+		JOIN Transits t ON t.curve_id = cl.curve_id AND t.transit_index = cl.transit_index + 1
+		JOIN Curves c ON cl.curve_id = c.id
+		WHERE cl.user_id = ? AND t.plot_file IS NOT NULL AND t.plot_file != ''
+		ORDER BY c.filename, t.transit_index
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plots := []ClassifiedPlot{}
+	for rows.Next() {
+		var p ClassifiedPlot
+		if err := rows.Scan(&p.Filename, &p.TransitIndex, &p.PlotFile); err != nil {
+			return nil, err
+		}
+		plots = append(plots, p)
+	}
+	return plots, rows.Err()
+}
+
 func GetAllFiles() []string {
 	rows, err := db.DB.Query(`
 		SELECT DISTINCT c.filename
@@ -267,6 +659,15 @@ func GetTransitCount(filename string) int {
 	return count
 }
 
+// TransitExists reports whether curveID has a transit with the 1-indexed index
+func TransitExists(curveID int64, index int) (bool, error) {
+	var exists bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM Transits WHERE curve_id = ? AND transit_index = ?)
+	`, curveID, index).Scan(&exists)
+	return exists, err
+}
+
 func GetTotalTransitCount() int {
 	var count int
 	err := db.DB.QueryRow("SELECT COUNT(*) FROM Transits").Scan(&count)
@@ -275,3 +676,290 @@ func GetTotalTransitCount() int {
 	}
 	return count
 }
+
+type TransitWithClassification struct {
+	Transit
+	Classification *Classification `json:"classification"`
+}
+
+func GetTransitsWithClassifications(curveID int64, userID int64) ([]TransitWithClassification, error) {
+	// Classifications store 0-indexed transit_index, Transits are 1-indexed
+	rows, err := db.DB.Query(`
+		SELECT t.id, t.curve_id, c.filename, t.transit_index, t.t0_expected, t.t0_fitted, t.ttv_minutes,
+			t.rp_fitted, t.a_fitted, t.rms_residuals, `+transitParamColumns+`, t.plot_file,
+			cl.id, cl.t_expected_bjd, cl.t_observed_bjd, cl.ttv_minutes,
+			COALESCE(cl.left_asymmetry, 0), COALESCE(cl.right_asymmetry, 0),
+			COALESCE(cl.increased_flux, 0), COALESCE(cl.decreased_flux, 0),
+			COALESCE(cl.normal_transit, 0), COALESCE(cl.anomalous_morphology, 0),
+			COALESCE(cl.marked_tdv, 0), COALESCE(cl.bad_model_fit, 0),
+			COALESCE(cl.notes, ''), COALESCE(cl.locked, 0), cl.timestamp
+		FROM Transits t
+		JOIN Curves c ON t.curve_id = c.id
+		LEFT JOIN Classifications cl
+			ON cl.curve_id = t.curve_id AND cl.transit_index = t.transit_index - 1 AND cl.user_id = ?
+		WHERE t.curve_id = ?
+		ORDER BY t.transit_index
+	`, userID, curveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transits := []TransitWithClassification{}
+	for rows.Next() {
+		var t TransitWithClassification
+		var cl Classification
+		var classificationID sql.NullInt64
+		var timestamp sql.NullTime
+		err := rows.Scan(&t.ID, &t.CurveID, &t.File, &t.TransitIndex, &t.T0Expected, &t.T0Fitted, &t.TTVMinutes,
+			&t.RpFitted, &t.AFitted, &t.RMSResiduals, &t.Period, &t.Duration, &t.Inc, &t.U1, &t.U2, &t.PlotFile,
+			&classificationID, &cl.TExpectedBJD, &cl.TObservedBJD, &cl.TTVMinutes,
+			&cl.LeftAsymmetry, &cl.RightAsymmetry,
+			&cl.IncreasedFlux, &cl.DecreasedFlux,
+			&cl.NormalTransit, &cl.AnomalousMorphology,
+			&cl.MarkedTDV, &cl.BadModelFit,
+			&cl.Notes, &cl.Locked, &timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if classificationID.Valid {
+			cl.ID = classificationID.Int64
+			cl.CurveID = t.CurveID
+			cl.TransitIndex = ToDBIndex(t.TransitIndex)
+			cl.UserID = userID
+			if timestamp.Valid {
+				cl.Timestamp = &timestamp.Time
+			}
+			t.Classification = &cl
+		}
+		transits = append(transits, t)
+	}
+	return transits, rows.Err()
+}
+
+type TransitRaterCount struct {
+	TransitIndex int `json:"transit_index"`
+	RaterCount   int `json:"rater_count"`
+}
+
+// GetRaterCountsForCurve returns how many users classified each transit of
+// the curve, including unrated ones, using the 1-indexed transit numbering
+func GetRaterCountsForCurve(curveID int64) ([]TransitRaterCount, error) {
+	rows, err := db.DB.Query(`
+		SELECT t.transit_index, COUNT(cl.id)
+		FROM Transits t
+		LEFT JOIN Classifications cl
+			ON cl.curve_id = t.curve_id AND cl.transit_index = t.transit_index - 1
+		WHERE t.curve_id = ?
+		GROUP BY t.transit_index
+		ORDER BY t.transit_index
+	`, curveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TransitRaterCount{}
+	for rows.Next() {
+		var rc TransitRaterCount
+		if err := rows.Scan(&rc.TransitIndex, &rc.RaterCount); err != nil {
+			return nil, err
+		}
+		counts = append(counts, rc)
+	}
+	return counts, rows.Err()
+}
+
+type CurveRater struct {
+	UserID          int64  `json:"user_id"`
+	Username        string `json:"username"`
+	ClassifiedCount int    `json:"classified_count"`
+	LastActivity    string `json:"last_activity"`
+}
+
+// GetCurveRaters lists every user who classified a transit of the curve,
+// most prolific first
+func GetCurveRaters(curveID int64) ([]CurveRater, error) {
+	rows, err := db.DB.Query(`
+		SELECT u.id, u.username, COUNT(*), MAX(cl.timestamp)
+		FROM Classifications cl
+		JOIN Users u ON cl.user_id = u.id
+		WHERE cl.curve_id = ?
+		GROUP BY u.id
+		ORDER BY COUNT(*) DESC, u.username
+	`, curveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	raters := []CurveRater{}
+	for rows.Next() {
+		var r CurveRater
+		var lastActivity sql.NullString
+		if err := rows.Scan(&r.UserID, &r.Username, &r.ClassifiedCount, &lastActivity); err != nil {
+			return nil, err
+		}
+		r.LastActivity = formatDBTimestamp(lastActivity.String)
+		raters = append(raters, r)
+	}
+	return raters, rows.Err()
+}
+
+// Fewer fitted transits than this leave too few others to score each one against
+const minTTVPoints = 3
+
+type TTVOutlier struct {
+	TransitIndex int     `json:"transit_index"`
+	TTVMinutes   float64 `json:"ttv_minutes"`
+	Sigmas       float64 `json:"sigmas"`
+}
+
+type TTVOutlierReport struct {
+	Count    int          `json:"count"`
+	Mean     *float64     `json:"mean"`
+	StdDev   *float64     `json:"stddev"`
+	Outliers []TTVOutlier `json:"outliers"`
+}
+
+// GetTTVOutliers returns transits whose TTV is more than sigma standard
+// deviations from the mean TTV of the curve's other transits. Leaving the
+// transit out keeps a single large outlier from inflating the spread it is
+// measured against, which would otherwise cap the score at (n-1)/sqrt(n).
+// With fewer than minTTVPoints fitted transits no outliers are reported, nor
+// for a transit whose others show no spread at all. Mean and StdDev describe
+// all fitted transits.
+func GetTTVOutliers(curveID int64, sigma float64) (*TTVOutlierReport, error) {
+	rows, err := db.DB.Query(`
+		SELECT transit_index, ttv_minutes FROM Transits
+		WHERE curve_id = ? AND ttv_minutes IS NOT NULL
+		ORDER BY transit_index
+	`, curveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []TTVOutlier
+	for rows.Next() {
+		var p TTVOutlier
+		if err := rows.Scan(&p.TransitIndex, &p.TTVMinutes); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &TTVOutlierReport{Count: len(points), Outliers: []TTVOutlier{}}
+	if len(points) < minTTVPoints {
+		return report, nil
+	}
+
+	n := float64(len(points))
+	var sum float64
+	for _, p := range points {
+		sum += p.TTVMinutes
+	}
+	mean := sum / n
+
+	var squares float64
+	for _, p := range points {
+		squares += (p.TTVMinutes - mean) * (p.TTVMinutes - mean)
+	}
+	stddev := math.Sqrt(squares / (n - 1))
+	report.Mean, report.StdDev = &mean, &stddev
+
+	for _, p := range points {
+		// With d the deviation from the overall mean, the other transits have
+		// mean -d/(n-1) and squared deviations summing to squares - d²n/(n-1)
+		d := p.TTVMinutes - mean
+		othersSquares := squares - d*d*n/(n-1)
+		if othersSquares <= squares*1e-12 {
+			continue
+		}
+		othersStdDev := math.Sqrt(othersSquares / (n - 2))
+		p.Sigmas = math.Abs(d) * n / (n - 1) / othersStdDev
+		if p.Sigmas > sigma {
+			report.Outliers = append(report.Outliers, p)
+		}
+	}
+	return report, nil
+}
+
+type UnderCoveredTransit struct {
+	CurveID      int64  `json:"curve_id"`
+	Filename     string `json:"filename"`
+	TransitIndex int    `json:"transit_index"`
+	PlotFile     string `json:"plot_file"`
+	RaterCount   int    `json:"rater_count"`
+}
+
+func GetUnderCoveredTransits(minRaters, limit int) ([]UnderCoveredTransit, error) {
+	// Classifications store 0-indexed transit_index, Transits are 1-indexed
+	rows, err := db.DB.Query(`
+		SELECT t.curve_id, c.filename, t.transit_index, t.plot_file,
+		       COUNT(DISTINCT cl.user_id) AS rater_count
+		FROM Transits t
+		JOIN Curves c ON t.curve_id = c.id
+		LEFT JOIN Classifications cl
+			ON cl.curve_id = t.curve_id AND cl.transit_index = t.transit_index - 1
+		GROUP BY t.id
+		HAVING rater_count < ?
+		ORDER BY rater_count, c.filename, t.transit_index
+		LIMIT ?
+	`, minRaters, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transits := []UnderCoveredTransit{}
+	for rows.Next() {
+		var t UnderCoveredTransit
+		if err := rows.Scan(&t.CurveID, &t.Filename, &t.TransitIndex, &t.PlotFile, &t.RaterCount); err != nil {
+			return nil, err
+		}
+		transits = append(transits, t)
+	}
+	return transits, rows.Err()
+}
+
+type DurationAnomaly struct {
+	TransitIndex     int     `json:"transit_index"`
+	DurationMinutes  float64 `json:"duration_minutes"`
+	ExpectedMinutes  float64 `json:"expected_minutes"`
+	DeviationMinutes float64 `json:"deviation_minutes"`
+}
+
+// GetDurationAnomalies returns transits whose fitted duration (minutes)
+// differs from the curve's nominal duration_days by more than threshold
+// minutes. Transits without a duration, or curves without a nominal one,
+// are skipped.
+func GetDurationAnomalies(curveID int64, threshold float64) ([]DurationAnomaly, error) {
+	rows, err := db.DB.Query(`
+		SELECT t.transit_index, t.duration, c.duration_days * 1440
+		FROM Transits t
+		JOIN Curves c ON t.curve_id = c.id
+		WHERE t.curve_id = ?
+		AND t.duration IS NOT NULL AND c.duration_days IS NOT NULL
+		AND ABS(t.duration - c.duration_days * 1440) > ?
+		ORDER BY t.transit_index
+	`, curveID, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	anomalies := []DurationAnomaly{}
+	for rows.Next() {
+		var a DurationAnomaly
+		if err := rows.Scan(&a.TransitIndex, &a.DurationMinutes, &a.ExpectedMinutes); err != nil {
+			return nil, err
+		}
+		a.DeviationMinutes = a.DurationMinutes - a.ExpectedMinutes
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, rows.Err()
+}
@@ -0,0 +1,510 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"emoons-web/db"
+	"emoons-web/db/dbtest"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func equalFloatPtr(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestGetTransitModelParams(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename, period_days, inclination_deg, u1, u2) VALUES
+		(1, 'full', 3.0, 89.0, 0.3, 0.2),
+		(2, 'bare', NULL, NULL, NULL, NULL)`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, t0_expected, t0_fitted, rp_fitted, a_fitted, period, inc, u1, u2) VALUES
+		(1, 1, 1.0, 1.001, 0.1, 10.0, 3.1, 88.0, 0.4, 0.1),
+		(1, 2, 4.0, NULL, 0.1, 10.0, NULL, NULL, NULL, NULL),
+		(2, 1, 2.0, NULL, NULL, NULL, NULL, NULL, NULL, NULL)`)
+
+	tests := []struct {
+		file  string
+		index int
+		want  TransitModelParams
+	}{
+		{"full", 1, TransitModelParams{
+			T0: floatPtr(1.001), Period: floatPtr(3.1), Rp: floatPtr(0.1), A: floatPtr(10), Inc: floatPtr(88),
+			U1: floatPtr(0.4), U2: floatPtr(0.1), LimbDarkeningSource: "transit",
+		}},
+		{"full", 2, TransitModelParams{
+			T0: floatPtr(4.0), Period: floatPtr(3.0), Rp: floatPtr(0.1), A: floatPtr(10), Inc: floatPtr(89),
+			U1: floatPtr(0.3), U2: floatPtr(0.2), LimbDarkeningSource: "curve",
+		}},
+		{"bare", 1, TransitModelParams{T0: floatPtr(2.0)}},
+	}
+	for _, tt := range tests {
+		got, err := GetTransitModelParams(tt.file, tt.index)
+		if err != nil {
+			t.Fatalf("%s #%d: %v", tt.file, tt.index, err)
+		}
+		if got == nil {
+			t.Fatalf("%s #%d: not found", tt.file, tt.index)
+		}
+		fields := []struct {
+			name      string
+			got, want *float64
+		}{
+			{"t0", got.T0, tt.want.T0},
+			{"period", got.Period, tt.want.Period},
+			{"rp", got.Rp, tt.want.Rp},
+			{"a", got.A, tt.want.A},
+			{"inc", got.Inc, tt.want.Inc},
+			{"u1", got.U1, tt.want.U1},
+			{"u2", got.U2, tt.want.U2},
+		}
+		for _, f := range fields {
+			if !equalFloatPtr(f.got, f.want) {
+				t.Errorf("%s #%d %s: got %v, want %v", tt.file, tt.index, f.name, f.got, f.want)
+			}
+		}
+		if got.LimbDarkeningSource != tt.want.LimbDarkeningSource {
+			t.Errorf("%s #%d: limb darkening source %q, want %q",
+				tt.file, tt.index, got.LimbDarkeningSource, tt.want.LimbDarkeningSource)
+		}
+	}
+
+	if got, err := GetTransitModelParams("full", 3); err != nil || got != nil {
+		t.Errorf("missing transit: got (%v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestGetTransitCurveFallback(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename, period_days, inclination_deg, u1, u2) VALUES (1, 'curveA', 3.0, 89.0, 0.3, 0.2)`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, t0_expected, rp_fitted, a_fitted, period, inc, u1, u2, plot_file) VALUES
+		(1, 1, 1.0, 0.1, 10.0, 0.0, 88.0, 0.0, NULL, 'a_1.png'),
+		(1, 2, 4.0, 0.1, 10.0, NULL, NULL, NULL, NULL, 'a_2.png')`)
+
+	// A stored zero is a value, not a missing one
+	got := GetTransit("curveA", 1)
+	if got == nil {
+		t.Fatal("transit 1 not found")
+	}
+	if !equalFloatPtr(got.Period, floatPtr(0)) || !equalFloatPtr(got.Inc, floatPtr(88)) ||
+		!equalFloatPtr(got.U1, floatPtr(0)) || !equalFloatPtr(got.U2, floatPtr(0.2)) {
+		t.Errorf("transit 1: got period %v inc %v u1 %v u2 %v", got.Period, got.Inc, got.U1, got.U2)
+	}
+
+	got = GetTransit("curveA", 2)
+	if got == nil {
+		t.Fatal("transit 2 not found")
+	}
+	if !equalFloatPtr(got.Period, floatPtr(3)) || !equalFloatPtr(got.Inc, floatPtr(89)) ||
+		!equalFloatPtr(got.U1, floatPtr(0.3)) || !equalFloatPtr(got.U2, floatPtr(0.2)) || got.Duration != nil {
+		t.Errorf("transit 2: got period %v inc %v u1 %v u2 %v duration %v",
+			got.Period, got.Inc, got.U1, got.U2, got.Duration)
+	}
+}
+
+func TestGetTimeNeighbors(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	// Transits 2 and 3 share a t0, and index order differs from time order
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, t0_expected, rp_fitted, a_fitted, plot_file) VALUES
+		(1, 1, 5.0, 0.1, 10.0, 'a_1.png'), (1, 2, 2.0, 0.1, 10.0, 'a_2.png'),
+		(1, 3, 2.0, 0.1, 10.0, 'a_3.png'), (1, 4, 1.0, 0.1, 10.0, 'a_4.png')`)
+
+	index := func(t *Transit) int {
+		if t == nil {
+			return 0
+		}
+		return t.TransitIndex
+	}
+	tests := []struct {
+		t0                 float64
+		index              int
+		wantPrev, wantNext int
+	}{
+		{1.0, 4, 0, 2},
+		{2.0, 2, 4, 3},
+		{2.0, 3, 2, 1},
+		{5.0, 1, 3, 0},
+	}
+	for _, tt := range tests {
+		prev, next, err := GetTimeNeighbors(1, tt.t0, tt.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index(prev) != tt.wantPrev || index(next) != tt.wantNext {
+			t.Errorf("transit %d: got neighbors (%d, %d), want (%d, %d)",
+				tt.index, index(prev), index(next), tt.wantPrev, tt.wantNext)
+		}
+	}
+}
+
+func TestGetTTVOutliers(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'spread'), (2, 'short'), (3, 'flat')`)
+	// With the outlier in the sample its z-score could never exceed (n-1)/sqrt(n) ≈ 1.79
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, ttv_minutes) VALUES
+		(1, 1, 0.0), (1, 2, 1.0), (1, 3, -1.0), (1, 4, 0.5), (1, 5, 30.0), (1, 6, NULL),
+		(2, 1, 0.0), (2, 2, 50.0),
+		(3, 1, 2.0), (3, 2, 2.0), (3, 3, 2.0), (3, 4, 9.0)`)
+
+	tests := []struct {
+		curveID int64
+		count   int
+		want    []int
+	}{
+		{1, 5, []int{5}},
+		{2, 2, nil},
+		{3, 4, nil},
+	}
+	for _, tt := range tests {
+		report, err := GetTTVOutliers(tt.curveID, 3)
+		if err != nil {
+			t.Fatalf("curve %d: %v", tt.curveID, err)
+		}
+		if report.Count != tt.count {
+			t.Errorf("curve %d: count %d, want %d", tt.curveID, report.Count, tt.count)
+		}
+		var got []int
+		for _, o := range report.Outliers {
+			got = append(got, o.TransitIndex)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("curve %d: outliers %v, want %v", tt.curveID, got, tt.want)
+		}
+	}
+}
+
+const transitsCSVHeader = "file,transit_index,t0_expected,t0_fitted,ttv_minutes,rp_fitted,a_fitted," +
+	"rms_residuals,period,duration,inc,u1,u2,plot_file\n"
+
+// transitCSVRow formats a plausible transit of file
+func transitCSVRow(file string, index int) string {
+	t0 := 1.0 + 3.0*float64(index-1)
+	return fmt.Sprintf("%s,%d,%g,%g,1.44,0.1,10,0.001,3.0,144,89,0.3,0.2,%s_%d.png\n",
+		file, index, t0, t0+0.001, file, index)
+}
+
+// writeTransitsCSV writes a transits CSV with n transits of curveA
+func writeTransitsCSV(tb testing.TB, n int, extra ...string) string {
+	tb.Helper()
+	var sb strings.Builder
+	sb.WriteString(transitsCSVHeader)
+	for i := 1; i <= n; i++ {
+		sb.WriteString(transitCSVRow("curveA", i))
+	}
+	for _, row := range extra {
+		sb.WriteString(row)
+	}
+
+	path := filepath.Join(tb.TempDir(), "transits.csv")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func countTransits(tb testing.TB) (transits, found int) {
+	tb.Helper()
+	err := db.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM Transits), (SELECT found_transits FROM Curves WHERE id = 1)`).
+		Scan(&transits, &found)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return transits, found
+}
+
+func TestLoadTransitsFromCSVCommitsInBatches(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+
+	rows := 2*transitImportBatchSize + 500
+	if err := LoadTransitsFromCSV(writeTransitsCSV(t, rows)); err != nil {
+		t.Fatal(err)
+	}
+	if transits, found := countTransits(t); transits != rows || found != rows {
+		t.Fatalf("%d transits, found_transits %d, want %d", transits, found, rows)
+	}
+
+	// A malformed line aborts the import; the batches committed before it stay
+	broken := writeTransitsCSV(t, 2*transitImportBatchSize, `curveA,9999,"unterminated`+"\n")
+	if err := LoadTransitsFromCSV(broken); err == nil {
+		t.Fatal("import of malformed CSV succeeded")
+	}
+	if transits, found := countTransits(t); transits != 2*transitImportBatchSize || found != 0 {
+		t.Errorf("after failed import: %d transits, found_transits %d, want %d and 0",
+			transits, found, 2*transitImportBatchSize)
+	}
+
+	// Running the import again starts over from a clear table
+	if err := LoadTransitsFromCSV(writeTransitsCSV(t, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if transits, found := countTransits(t); transits != 3 || found != 3 {
+		t.Errorf("after retry: %d transits, found_transits %d, want 3", transits, found)
+	}
+}
+
+// peakHeap samples the live heap while f runs and returns the highest value
+func peakHeap(f func()) uint64 {
+	var peak uint64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var m runtime.MemStats
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&m)
+			peak = max(peak, m.HeapAlloc)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	f()
+	close(done)
+	wg.Wait()
+	return peak
+}
+
+// BenchmarkLoadTransitsMemory imports files of growing size; since rows are
+// streamed, the peak heap should stay flat instead of growing with the file
+func BenchmarkLoadTransitsMemory(b *testing.B) {
+	for _, rows := range []int{1000, 10000, 50000} {
+		b.Run(fmt.Sprintf("rows=%d", rows), func(b *testing.B) {
+			setupTestDB(b)
+			dbtest.Exec(b, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+			path := writeTransitsCSV(b, rows)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				peak = max(peak, peakHeap(func() {
+					if err := LoadTransitsFromCSV(path); err != nil {
+						b.Fatal(err)
+					}
+				}))
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+		})
+	}
+}
+
+func TestLoadTransitsFromCSVRetriesFailedBatch(t *testing.T) {
+	setupTestDB(t)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+
+	defer func(size int) { TransitInsertBatchSize = size }(TransitInsertBatchSize)
+	TransitInsertBatchSize = 4
+
+	// The duplicate of transit 2 fails the second batch as a whole; retrying
+	// it row by row keeps the rows around it
+	path := writeTransitsCSV(t, 5, transitCSVRow("curveA", 2), transitCSVRow("curveA", 6), transitCSVRow("curveA", 7))
+	if err := LoadTransitsFromCSV(path); err != nil {
+		t.Fatal(err)
+	}
+	if transits, found := countTransits(t); transits != 7 || found != 7 {
+		t.Errorf("got %d transits, found_transits %d, want 7", transits, found)
+	}
+}
+
+// BenchmarkLoadTransits compares one-row inserts with multi-row batches
+func BenchmarkLoadTransits(b *testing.B) {
+	defer func(size int) { TransitInsertBatchSize = size }(TransitInsertBatchSize)
+
+	for _, size := range []int{1, 100, maxTransitInsertBatch} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			setupTestDB(b)
+			dbtest.Exec(b, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+			path := writeTransitsCSV(b, 20000)
+			TransitInsertBatchSize = size
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := LoadTransitsFromCSV(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTransitRowInsert compares re-sending the INSERT text for every row
+// with the prepared statement the import reuses
+func BenchmarkTransitRowInsert(b *testing.B) {
+	const rows = 5000
+	setupTestDB(b)
+	dbtest.Exec(b, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	query := transitInsertSQL(1)
+
+	run := func(b *testing.B, prepare bool) {
+		for i := 0; i < b.N; i++ {
+			tx, err := db.DB.Begin()
+			if err != nil {
+				b.Fatal(err)
+			}
+			exec := func(args ...any) (sql.Result, error) { return tx.Exec(query, args...) }
+			if prepare {
+				stmt, err := tx.Prepare(query)
+				if err != nil {
+					b.Fatal(err)
+				}
+				exec = stmt.Exec
+			}
+			for index := 1; index <= rows; index++ {
+				p := pendingTransit{transitRecord: transitRecord{filename: "curveA", transitIndex: index}, curveID: 1}
+				if _, err := exec(p.args()...); err != nil {
+					b.Fatal(err)
+				}
+			}
+			// Roll back so every iteration inserts into an empty table
+			tx.Rollback()
+		}
+	}
+
+	b.Run("exec", func(b *testing.B) { run(b, false) })
+	b.Run("prepared", func(b *testing.B) { run(b, true) })
+}
+
+// seedCurvesWithTransits adds curves 1..curves where curve i has i%7
+// transits, and found_transits set to a stale -1
+func seedCurvesWithTransits(tb testing.TB, curves int) {
+	tb.Helper()
+	tx, err := db.DB.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+	for id := 1; id <= curves; id++ {
+		if _, err := tx.Exec(`INSERT INTO Curves (id, filename, found_transits) VALUES (?, ?, -1)`,
+			id, fmt.Sprintf("curve%d", id)); err != nil {
+			tb.Fatal(err)
+		}
+		for index := 1; index <= id%7; index++ {
+			if _, err := tx.Exec(`INSERT INTO Transits (curve_id, transit_index) VALUES (?, ?)`, id, index); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// updateFoundTransitsPerCurve is the one-UPDATE-per-curve loop the
+// set-based recount replaced, kept as the reference it must agree with
+func updateFoundTransitsPerCurve() error {
+	rows, err := db.DB.Query(`SELECT c.id, COUNT(t.id) FROM Curves c LEFT JOIN Transits t ON t.curve_id = c.id GROUP BY c.id`)
+	if err != nil {
+		return err
+	}
+	counts := map[int64]int{}
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		counts[id] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, n := range counts {
+		if _, err := db.DB.Exec(`UPDATE Curves SET found_transits = ? WHERE id = ?`, n, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func foundTransitsByCurve(tb testing.TB) map[int64]int {
+	tb.Helper()
+	rows, err := db.DB.Query(`SELECT id, found_transits FROM Curves`)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer rows.Close()
+	counts := map[int64]int{}
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			tb.Fatal(err)
+		}
+		counts[id] = n
+	}
+	return counts
+}
+
+func TestUpdateFoundTransitsMatchesPerCurveLoop(t *testing.T) {
+	setupTestDB(t)
+	seedCurvesWithTransits(t, 30)
+
+	recount := func(update func() error) map[int64]int {
+		dbtest.Exec(t, `UPDATE Curves SET found_transits = -1`)
+		if err := update(); err != nil {
+			t.Fatal(err)
+		}
+		return foundTransitsByCurve(t)
+	}
+
+	want := recount(updateFoundTransitsPerCurve)
+	got := recount(updateFoundTransits)
+	if len(got) != 30 {
+		t.Fatalf("got %d curves, want 30", len(got))
+	}
+	for id, n := range want {
+		if got[id] != n {
+			t.Errorf("curve %d: found_transits %d, per-curve loop gives %d", id, got[id], n)
+		}
+	}
+	if got[7] != 0 {
+		t.Errorf("curve without transits: found_transits %d, want 0", got[7])
+	}
+}
+
+// BenchmarkUpdateFoundTransits reruns each recount over the same data; both
+// are idempotent, so every iteration does the same work
+func BenchmarkUpdateFoundTransits(b *testing.B) {
+	setupTestDB(b)
+	seedCurvesWithTransits(b, 5000)
+
+	for _, bench := range []struct {
+		name   string
+		update func() error
+	}{
+		{"per-curve", updateFoundTransitsPerCurve},
+		{"set-based", updateFoundTransits},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := bench.update(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -3,17 +3,65 @@ package models
 import (
 	"database/sql"
 	"emoons-web/db"
+	"errors"
 	"log"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	RoleUser     = "user"
+	RoleReviewer = "reviewer"
+	RoleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:     1,
+	RoleReviewer: 2,
+	RoleAdmin:    3,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants the permissions of min
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] >= roleRanks[min]
+}
+
+var (
+	ErrInvalidUsername = errors.New("username must be non-empty and contain no control characters")
+	ErrUsernameTaken   = errors.New("username already exists")
+)
+
+// NormalizeUsername trims and lowercases a username so lookups are case-insensitive
+func NormalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" || strings.ContainsFunc(username, unicode.IsControl) {
+		return "", ErrInvalidUsername
+	}
+	return username, nil
+}
+
 type User struct {
-	ID           int64  `json:"id"`
-	Username     string `json:"username"`
-	PasswordHash string `json:"-"`
-	Fullname     string `json:"fullname"`
-	IsAdmin      bool   `json:"is_admin"`
+	ID           int64      `json:"id"`
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"-"`
+	Fullname     string     `json:"fullname"`
+	Role         string     `json:"role"`
+	Active       bool       `json:"active"`
+	IsAdmin      bool       `json:"is_admin"`
+	IsGuest      bool       `json:"is_guest,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+	// nil means unlimited
+	ClassificationQuota *int `json:"classification_quota"`
 }
 
 type UserWithStats struct {
@@ -24,32 +72,36 @@ type UserWithStats struct {
 }
 
 func GetUserByUsername(username string) (*User, error) {
+	username, err := NormalizeUsername(username)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
 	var user User
-	var isAdmin int
-	err := db.DB.QueryRow(
-		"SELECT id, username, password_hash, fullname, is_admin FROM Users WHERE username = ?",
+	// NOCASE also matches accounts created before usernames were normalized
+	err = db.DB.QueryRow(
+		"SELECT id, username, password_hash, fullname, role, active, created_at, updated_at, classification_quota FROM Users WHERE username = ? COLLATE NOCASE",
 		username,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Fullname, &isAdmin)
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Fullname, &user.Role, &user.Active, &user.CreatedAt, &user.UpdatedAt, &user.ClassificationQuota)
 
 	if err != nil {
 		return nil, err
 	}
-	user.IsAdmin = isAdmin == 1
+	user.IsAdmin = user.Role == RoleAdmin
 	return &user, nil
 }
 
 func GetUserByID(id int64) (*User, error) {
 	var user User
-	var isAdmin int
 	err := db.DB.QueryRow(
-		"SELECT id, username, password_hash, fullname, is_admin FROM Users WHERE id = ?",
+		"SELECT id, username, password_hash, fullname, role, active, created_at, updated_at, classification_quota FROM Users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Fullname, &isAdmin)
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Fullname, &user.Role, &user.Active, &user.CreatedAt, &user.UpdatedAt, &user.ClassificationQuota)
 
 	if err != nil {
 		return nil, err
 	}
-	user.IsAdmin = isAdmin == 1
+	user.IsAdmin = user.Role == RoleAdmin
 	return &user, nil
 }
 
@@ -61,7 +113,7 @@ func (u *User) CheckPassword(password string) bool {
 func ListUsers() ([]UserWithStats, error) {
 	rows, err := db.DB.Query(`
 		SELECT
-			u.id, u.username, u.fullname, u.is_admin,
+			u.id, u.username, u.fullname, u.role, u.active, u.created_at, u.updated_at, u.classification_quota,
 			COUNT(c.id) as classified_transits,
 			MAX(c.timestamp) as last_activity
 		FROM Users u
@@ -79,36 +131,46 @@ func ListUsers() ([]UserWithStats, error) {
 	var users []UserWithStats
 	for rows.Next() {
 		var u UserWithStats
-		var isAdmin int
 		var lastActivity sql.NullString
-		if err := rows.Scan(&u.ID, &u.Username, &u.Fullname, &isAdmin, &u.ClassifiedTransits, &lastActivity); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Fullname, &u.Role, &u.Active, &u.CreatedAt, &u.UpdatedAt, &u.ClassificationQuota,
+			&u.ClassifiedTransits, &lastActivity); err != nil {
 			return nil, err
 		}
-		u.IsAdmin = isAdmin == 1
+		u.IsAdmin = u.Role == RoleAdmin
 		u.TotalTransits = totalTransits
 		if lastActivity.Valid {
-			u.LastActivity = lastActivity.String
+			u.LastActivity = formatDBTimestamp(lastActivity.String)
 		}
 		users = append(users, u)
 	}
 	return users, rows.Err()
 }
 
-func CreateUser(username, password, fullname string, isAdmin bool) (*User, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func CreateUser(username, password, fullname, role string) (*User, error) {
+	username, err := NormalizeUsername(username)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := GetUserByUsername(username); err == nil {
+		return nil, ErrUsernameTaken
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
 
-	isAdminInt := 0
-	if isAdmin {
-		isAdminInt = 1
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := db.DB.Exec(
-		"INSERT INTO Users (username, password_hash, fullname, is_admin) VALUES (?, ?, ?, ?)",
-		username, string(hash), fullname, isAdminInt,
-	)
+	result, err := db.DB.Exec(`
+		INSERT INTO Users (username, password_hash, fullname, role, is_admin, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, username, string(hash), fullname, role, role == RoleAdmin)
+	// A concurrent create can slip between the lookup above and the insert
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return nil, ErrUsernameTaken
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -118,37 +180,124 @@ func CreateUser(username, password, fullname string, isAdmin bool) (*User, error
 		return nil, err
 	}
 
-	return &User{
-		ID:       id,
-		Username: username,
-		Fullname: fullname,
-		IsAdmin:  isAdmin,
-	}, nil
+	return GetUserByID(id)
+}
+
+var ErrLastAdmin = errors.New("cannot remove the last admin")
+
+// leavesActiveAdmin holds for a Users row that can lose admin rights while
+// another active admin remains to manage users
+const leavesActiveAdmin = `(role != '` + RoleAdmin + `' OR active = 0 OR EXISTS (
+	SELECT 1 FROM Users other
+	WHERE other.role = '` + RoleAdmin + `' AND other.active = 1 AND other.id != Users.id
+))`
+
+// lastAdminOrMissing explains a guarded write to user id that changed nothing
+func lastAdminOrMissing(q interface {
+	QueryRow(string, ...any) *sql.Row
+}, id int64) error {
+	var exists bool
+	if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM Users WHERE id = ?)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrLastAdmin
+	}
+	return ErrUnknownUser
 }
 
-func UpdateUser(id int64, fullname string, isAdmin bool) error {
-	isAdminInt := 0
-	if isAdmin {
-		isAdminInt = 1
+// UpdateUser sets the user's name and role. Demoting the only active admin
+// fails with ErrLastAdmin; the check is part of the UPDATE, so concurrent
+// demotions can't both pass it.
+func UpdateUser(id int64, fullname, role string) error {
+	result, err := db.DB.Exec(`
+		UPDATE Users SET fullname = ?, role = ?, is_admin = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (? OR `+leavesActiveAdmin+`)
+	`, fullname, role, role == RoleAdmin, id, role == RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
 	}
+	return lastAdminOrMissing(db.DB, id)
+}
+
+// CountAdmins counts the admins who can still log in
+func CountAdmins() (int, error) {
+	var n int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM Users WHERE role = ? AND active = 1", RoleAdmin).Scan(&n)
+	return n, err
+}
+
+func SetClassificationQuota(id int64, quota *int) error {
+	_, err := db.DB.Exec(
+		"UPDATE Users SET classification_quota = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		quota, id,
+	)
+	return err
+}
 
+// SetUserActive enables or disables logins for the user without touching their data
+func SetUserActive(id int64, active bool) error {
 	_, err := db.DB.Exec(
-		"UPDATE Users SET fullname = ?, is_admin = ? WHERE id = ?",
-		fullname, isAdminInt, id,
+		"UPDATE Users SET active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		active, id,
 	)
 	return err
 }
 
+// GetRemainingQuota returns how many more classifications the user may save,
+// or nil if the user has no quota
+func GetRemainingQuota(userID int64) (*int, error) {
+	var quota sql.NullInt64
+	var used int
+	err := db.DB.QueryRow(`
+		SELECT u.classification_quota,
+		       (SELECT COUNT(*) FROM Classifications WHERE user_id = u.id)
+		FROM Users u WHERE u.id = ?
+	`, userID).Scan(&quota, &used)
+	if err == sql.ErrNoRows || (err == nil && !quota.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := max(int(quota.Int64)-used, 0)
+	return &remaining, nil
+}
+
+// DeleteUser removes the user and their classifications, failing with
+// ErrLastAdmin for the only active admin and ErrUnknownUser for unknown users
 func DeleteUser(id int64) error {
-	// Delete user's classifications first
-	_, err := db.DB.Exec("DELETE FROM Classifications WHERE user_id = ?", id)
+	defer InvalidateUserStats(id)
+
+	tx, err := db.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Delete the user
-	_, err = db.DB.Exec("DELETE FROM Users WHERE id = ?", id)
-	return err
+	// Delete user's classifications first; this also takes the write lock,
+	// so the admin check below can't race another removal
+	_, err = tx.Exec("DELETE FROM Classifications WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM Users WHERE id = ? AND "+leavesActiveAdmin, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return lastAdminOrMissing(tx, id)
+	}
+	return tx.Commit()
 }
 
 func EnsureAdminUser(username, password string) error {
@@ -156,7 +305,7 @@ func EnsureAdminUser(username, password string) error {
 	user, err := GetUserByUsername(username)
 	if err == sql.ErrNoRows {
 		// Create admin user
-		_, err = CreateUser(username, password, "Administrator", true)
+		_, err = CreateUser(username, password, "Administrator", RoleAdmin)
 		if err != nil {
 			return err
 		}
@@ -169,7 +318,7 @@ func EnsureAdminUser(username, password string) error {
 
 	// Ensure user is admin
 	if !user.IsAdmin {
-		err = UpdateUser(user.ID, user.Fullname, true)
+		err = UpdateUser(user.ID, user.Fullname, RoleAdmin)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"emoons-web/db"
+)
+
+// Detailed user stats take several aggregate queries, so the admin views read
+// them from memory. Entries are dropped when the user's classifications change
+// and rebuilt by StartUserStatsRefresh in the background.
+var userStatsCache = struct {
+	sync.RWMutex
+	entries map[int64]*DetailedUserStats
+	// Bumped on invalidation so a refresh that raced with a save doesn't store stale stats
+	generation uint64
+}{
+	entries: map[int64]*DetailedUserStats{},
+}
+
+// InvalidateUserStats drops userID's cached stats; call it after their classifications change
+func InvalidateUserStats(userID int64) {
+	userStatsCache.Lock()
+	defer userStatsCache.Unlock()
+	delete(userStatsCache.entries, userID)
+	userStatsCache.generation++
+}
+
+// InvalidateAllUserStats drops every cached entry
+func InvalidateAllUserStats() {
+	userStatsCache.Lock()
+	defer userStatsCache.Unlock()
+	userStatsCache.entries = map[int64]*DetailedUserStats{}
+	userStatsCache.generation++
+}
+
+// GetCachedUserStats returns userID's stats from the cache, computing them on
+// a miss or when fresh is set. ComputedAt tells how old they are.
+func GetCachedUserStats(ctx context.Context, userID int64, fresh bool) (*DetailedUserStats, error) {
+	userStatsCache.RLock()
+	cached, ok := userStatsCache.entries[userID]
+	userStatsCache.RUnlock()
+	if ok && !fresh {
+		s := *cached
+		return &s, nil
+	}
+	return refreshUserStats(ctx, userID)
+}
+
+func refreshUserStats(ctx context.Context, userID int64) (*DetailedUserStats, error) {
+	userStatsCache.RLock()
+	generation := userStatsCache.generation
+	userStatsCache.RUnlock()
+
+	stats, err := GetDetailedUserStats(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	stats.ComputedAt = time.Now().UTC().Format(time.RFC3339)
+
+	userStatsCache.Lock()
+	if userStatsCache.generation == generation {
+		s := *stats
+		userStatsCache.entries[userID] = &s
+	}
+	userStatsCache.Unlock()
+	return stats, nil
+}
+
+// RefreshUserStatsCache recomputes the stats of every user with classifications
+// and drops the entries of users that no longer have any
+func RefreshUserStatsCache(ctx context.Context) error {
+	rows, err := db.ReadDB.QueryContext(ctx, "SELECT DISTINCT user_id FROM Classifications")
+	if err != nil {
+		return err
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	seen := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		seen[id] = true
+	}
+	userStatsCache.Lock()
+	for id := range userStatsCache.entries {
+		if !seen[id] {
+			delete(userStatsCache.entries, id)
+		}
+	}
+	userStatsCache.Unlock()
+
+	for _, id := range userIDs {
+		if _, err := refreshUserStats(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartUserStatsRefresh fills the stats cache now and then every interval
+// until the process exits
+func StartUserStatsRefresh(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := RefreshUserStatsCache(context.Background()); err != nil {
+				log.Printf("User stats refresh failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
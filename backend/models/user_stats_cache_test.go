@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"emoons-web/db/dbtest"
+)
+
+func cachedUserStats(userID int64) bool {
+	userStatsCache.RLock()
+	defer userStatsCache.RUnlock()
+	_, ok := userStatsCache.entries[userID]
+	return ok
+}
+
+func TestUserStatsCacheDropsRemovedUsers(t *testing.T) {
+	setupTestDB(t)
+	seedUsers(t, 3)
+	dbtest.Exec(t, `INSERT INTO Curves (id, filename) VALUES (1, 'curveA')`)
+	dbtest.Exec(t, `INSERT INTO Transits (curve_id, transit_index, plot_file) VALUES (1, 1, 'a_1.png')`)
+	dbtest.Exec(t, `INSERT INTO Classifications (curve_id, transit_index, user_id, normal_transit) VALUES (1, 0, 1, 1), (1, 0, 2, 1), (1, 0, 3, 1)`)
+
+	ctx := context.Background()
+	if err := RefreshUserStatsCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		if !cachedUserStats(id) {
+			t.Fatalf("user %d not cached after refresh", id)
+		}
+	}
+
+	if err := DeleteUser(2); err != nil {
+		t.Fatal(err)
+	}
+	if cachedUserStats(2) {
+		t.Error("deleted user's stats are still cached")
+	}
+
+	// Rows removed behind the cache's back are caught by the next refresh
+	dbtest.Exec(t, `DELETE FROM Classifications WHERE user_id = 3`)
+	if err := RefreshUserStatsCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cachedUserStats(3) {
+		t.Error("refresh kept stats of a user without classifications")
+	}
+	if !cachedUserStats(1) {
+		t.Error("refresh dropped stats of a user with classifications")
+	}
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"emoons-web/db"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"alice", "alice", nil},
+		{"  Alice\t", "alice", nil},
+		{"ADMIN", "admin", nil},
+		{"", "", ErrInvalidUsername},
+		{"   ", "", ErrInvalidUsername},
+		{"ali\x00ce", "", ErrInvalidUsername},
+		{"ali\nce", "", ErrInvalidUsername},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeUsername(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("NormalizeUsername(%q) = (%q, %v), want (%q, %v)", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCreateUserCollisions(t *testing.T) {
+	setupTestDB(t)
+
+	if _, err := CreateUser(" Alice ", "password", "Alice", RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "ALICE", "  aLiCe"} {
+		if _, err := CreateUser(name, "password", "Other", RoleUser); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("CreateUser(%q): got %v, want ErrUsernameTaken", name, err)
+		}
+	}
+
+	// Logins find the account whatever the case
+	for _, name := range []string{"alice", "ALICE"} {
+		user, err := GetUserByUsername(name)
+		if err != nil || user.Username != "alice" {
+			t.Errorf("GetUserByUsername(%q): got (%v, %v)", name, user, err)
+		}
+	}
+
+	// The unique index backs up the lookup when a row skips normalization
+	if _, err := CreateUser("bob", "password", "Bob", RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(`INSERT INTO Users (username, password_hash, fullname) VALUES ('BOB', 'x', 'x')`); err == nil {
+		t.Error("inserted a username differing only in case")
+	}
+}
+
+func TestLastAdminCannotBeRemoved(t *testing.T) {
+	setupTestDB(t)
+	first, err := CreateUser("first", "password", "First", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := CreateUser("plain", "password", "Plain", RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateUser(first.ID, "First", RoleUser); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("demoting the only admin: got %v, want ErrLastAdmin", err)
+	}
+	if err := UpdateUser(first.ID, "Renamed", RoleAdmin); err != nil {
+		t.Errorf("renaming the only admin: %v", err)
+	}
+	if err := DeleteUser(first.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("deleting the only admin: got %v, want ErrLastAdmin", err)
+	}
+	if err := UpdateUser(999, "Nobody", RoleUser); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("updating an unknown user: got %v, want ErrUnknownUser", err)
+	}
+	if err := DeleteUser(999); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("deleting an unknown user: got %v, want ErrUnknownUser", err)
+	}
+
+	// A deactivated admin can't manage users, so it doesn't count
+	second, err := CreateUser("second", "password", "Second", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetUserActive(second.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUser(first.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("deleting the only active admin: got %v, want ErrLastAdmin", err)
+	}
+	if err := DeleteUser(second.ID); err != nil {
+		t.Errorf("deleting an inactive admin: %v", err)
+	}
+
+	if err := UpdateUser(user.ID, "Plain", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUser(first.ID); err != nil {
+		t.Errorf("deleting one of two admins: %v", err)
+	}
+	if n, err := CountAdmins(); err != nil || n != 1 {
+		t.Errorf("CountAdmins() = (%d, %v), want 1", n, err)
+	}
+}
+
+func TestConcurrentDemotionsKeepAnAdmin(t *testing.T) {
+	setupTestDB(t)
+	var ids []int64
+	for _, name := range []string{"first", "second"} {
+		admin, err := CreateUser(name, "password", name, RoleAdmin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, admin.ID)
+	}
+
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i == 0 {
+				errs[i] = UpdateUser(id, "demoted", RoleUser)
+			} else {
+				errs[i] = DeleteUser(id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var refused int
+	for _, err := range errs {
+		if errors.Is(err, ErrLastAdmin) {
+			refused++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := CountAdmins(); err != nil || n != 1 || refused != 1 {
+		t.Errorf("got %d admins (%v) with %d refused, want 1 admin and 1 refused", n, err, refused)
+	}
+}
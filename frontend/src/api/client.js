@@ -70,6 +70,10 @@ export const api = {
   getTransitsByFile: (file) =>
     request('GET', `/transits/${encodeURIComponent(file)}`),
 
+  // Signed URL an <img> can load without the Authorization header
+  getPlotURL: (file) =>
+    request('GET', `/plots/${encodeURIComponent(file)}/url`),
+
   // Classifications
   getClassification: (file, index) =>
     request('GET', `/transits/${encodeURIComponent(file)}/${index}/classify`),
@@ -77,6 +81,9 @@ export const api = {
   saveClassification: (file, index, data) =>
     request('POST', `/transits/${encodeURIComponent(file)}/${index}/classify`, data),
 
+  deleteClassification: (file, index) =>
+    request('DELETE', `/transits/${encodeURIComponent(file)}/${index}/classify`),
+
   deleteCurveClassifications: (curveId) =>
     request('DELETE', `/curves/${curveId}/classifications`),
 
@@ -84,7 +84,14 @@ export function ClassificationForm({ file, transitIndex, onSaved }) {
 
     try {
       setSaving(true)
-      await api.saveClassification(file, transitIndex, data)
+      // The backend rejects empty classifications, so clearing the form deletes it
+      const isEmpty = !data.notes.trim() &&
+        Object.entries(data).every(([key, value]) => key === 'notes' || !value)
+      if (isEmpty) {
+        await api.deleteClassification(file, transitIndex)
+      } else {
+        await api.saveClassification(file, transitIndex, data)
+      }
       if (onSaved) onSaved()
     } catch (err) {
       console.error('Failed to save classification:', err)
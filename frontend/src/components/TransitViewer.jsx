@@ -10,6 +10,7 @@ export function TransitViewer({ curve, onClassificationSaved, onNextCurve, onPre
   const [transits, setTransits] = useState([])
   const [currentIndex, setCurrentIndex] = useState(0)
   const [currentTransit, setCurrentTransit] = useState(null)
+  const [plotUrl, setPlotUrl] = useState('')
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
   const [showDeleteDialog, setShowDeleteDialog] = useState(false)
@@ -28,6 +29,19 @@ export function TransitViewer({ curve, onClassificationSaved, onNextCurve, onPre
     }
   }, [transits, currentIndex])
 
+  useEffect(() => {
+    const plotFile = currentTransit?.plot_file
+    setPlotUrl('')
+    if (!plotFile) return
+
+    // Ignore the answer if the user already moved to another transit
+    let current = true
+    api.getPlotURL(plotFile)
+      .then(data => { if (current) setPlotUrl(data.url) })
+      .catch(err => console.error('Failed to get plot URL:', err))
+    return () => { current = false }
+  }, [currentTransit?.plot_file])
+
   const goToPrevious = useCallback(() => {
     if (currentIndex > 0) {
       setCurrentIndex(currentIndex - 1)
@@ -142,11 +156,15 @@ export function TransitViewer({ curve, onClassificationSaved, onNextCurve, onPre
         <div class="flex-1 flex flex-col min-w-0 min-h-0 bg-base-300">
           <div class="flex-1 flex items-center justify-center p-4 pb-8 min-h-0">
             {currentTransit?.plot_file ? (
-              <img
-                src={`/plots/${currentTransit.plot_file}`}
-                alt={`Transit ${currentIndex + 1}`}
-                class="max-w-full max-h-full object-contain dark-invert"
-              />
+              plotUrl ? (
+                <img
+                  src={plotUrl}
+                  alt={`Transit ${currentIndex + 1}`}
+                  class="max-w-full max-h-full object-contain dark-invert"
+                />
+              ) : (
+                <span class="loading loading-spinner loading-lg"></span>
+              )
             ) : (
               <div class="text-center text-base-content/50">
                 <svg xmlns="http://www.w3.org/2000/svg" class="h-12 w-12 mx-auto mb-3 opacity-40" fill="none" viewBox="0 0 24 24" stroke="currentColor">